@@ -1,429 +1,796 @@
 package main
 
 import (
+	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/wider-cli-plugin/pkg/wider"
+	"sigs.k8s.io/yaml"
 )
 
-func TestFormatAge(t *testing.T) {
+func TestOptionsValidate(t *testing.T) {
 	tests := []struct {
-		name     string
-		duration time.Duration
-		expected string
+		name         string
+		outputFormat string
+		wantErr      bool
 	}{
 		{
-			name:     "30 seconds",
-			duration: 30 * time.Second,
-			expected: "30s",
+			name:         "empty format",
+			outputFormat: "",
+			wantErr:      false,
 		},
 		{
-			name:     "5 minutes",
-			duration: 5 * time.Minute,
-			expected: "5m",
+			name:         "valid custom-columns",
+			outputFormat: "custom-columns=NAME:.pod.metadata.name",
+			wantErr:      false,
 		},
 		{
-			name:     "2 hours",
-			duration: 2 * time.Hour,
-			expected: "2h",
+			name:         "valid json",
+			outputFormat: "json",
+			wantErr:      false,
 		},
 		{
-			name:     "29 hours",
-			duration: 29 * time.Hour,
-			expected: "29h",
+			name:         "valid yaml",
+			outputFormat: "yaml",
+			wantErr:      false,
 		},
 		{
-			name:     "47 hours",
-			duration: 47 * time.Hour,
-			expected: "47h",
+			name:         "valid wide",
+			outputFormat: "wide",
+			wantErr:      false,
 		},
 		{
-			name:     "2 days",
-			duration: 48 * time.Hour,
-			expected: "2d",
+			name:         "valid jsonl",
+			outputFormat: "jsonl",
+			wantErr:      false,
 		},
 		{
-			name:     "5 days",
-			duration: 5 * 24 * time.Hour,
-			expected: "5d",
+			name:         "valid ndjson",
+			outputFormat: "ndjson",
+			wantErr:      false,
+		},
+		{
+			name:         "valid csv",
+			outputFormat: "csv=NAME:.pod.metadata.name",
+			wantErr:      false,
+		},
+		{
+			name:         "valid tsv",
+			outputFormat: "tsv=NAME:.pod.metadata.name",
+			wantErr:      false,
+		},
+		{
+			name:         "invalid columns kind",
+			outputFormat: "xsv=NAME:.pod.metadata.name",
+			wantErr:      true,
+		},
+		{
+			name:         "valid name",
+			outputFormat: "name",
+			wantErr:      false,
+		},
+		{
+			name:         "invalid format",
+			outputFormat: "xml",
+			wantErr:      true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			creationTime := metav1.NewTime(time.Now().Add(-tt.duration))
-			result := formatAge(creationTime)
-			if result != tt.expected {
-				t.Errorf("formatAge() = %v, want %v", result, tt.expected)
+			opts := &Options{
+				OutputFormat: tt.outputFormat,
+			}
+			err := opts.Validate()
+			if tt.wantErr && err == nil {
+				t.Error("expected error but got none")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
 			}
 		})
 	}
 }
 
-func TestCapitalizeFirst(t *testing.T) {
+func TestOptionsValidate_Contexts(t *testing.T) {
+	opts := &Options{
+		Contexts:    []string{"ctx1", "ctx2"},
+		AllContexts: true,
+	}
+	if err := opts.Validate(); err == nil {
+		t.Error("expected error when combining --contexts and --all-contexts")
+	}
+}
+
+func TestConfigFlagsForContext(t *testing.T) {
+	opts := NewWiderOptions(genericiooptions.NewTestIOStreamsDiscard())
+	user := "jane"
+	opts.ConfigFlags.Impersonate = &user
+
+	flags := opts.configFlagsForContext("prod")
+	if flags.Context == nil || *flags.Context != "prod" {
+		t.Errorf("expected context prod, got %v", flags.Context)
+	}
+	if flags.Impersonate == nil || *flags.Impersonate != "jane" {
+		t.Errorf("expected impersonation to be preserved, got %v", flags.Impersonate)
+	}
+	if opts.ConfigFlags.Context != nil && *opts.ConfigFlags.Context == "prod" {
+		t.Error("expected original config flags to be left untouched")
+	}
+}
+
+func TestOptionsValidate_ContextWithFanOut(t *testing.T) {
+	opts := &Options{
+		AllContexts: true,
+		ConfigFlags: genericclioptions.NewConfigFlags(true),
+	}
+	contextName := "staging"
+	opts.ConfigFlags.Context = &contextName
+	if err := opts.Validate(); err == nil {
+		t.Error("expected error when combining --context and --all-contexts")
+	}
+}
+
+func TestOptionsValidate_GroupBy(t *testing.T) {
 	tests := []struct {
-		input    string
-		expected string
+		name         string
+		outputFormat string
+		groupBy      string
+		wantErr      bool
 	}{
-		{"metadata", "Metadata"},
-		{"name", "Name"},
-		{"status", "Status"},
-		{"", ""},
-		{"a", "A"},
-		{"ABC", "ABC"},
+		{"markdown", "markdown", ".node.metadata.labels.topology\\.kubernetes\\.io/zone", false},
+		{"html columns", "html=NAME:.pod.metadata.name", ".pod.metadata.namespace", false},
+		{"table", "", ".pod.metadata.namespace", true},
+		{"invalid path", "markdown", ".", true},
 	}
 
 	for _, tt := range tests {
-		t.Run(tt.input, func(t *testing.T) {
-			result := capitalizeFirst(tt.input)
-			if result != tt.expected {
-				t.Errorf("capitalizeFirst(%q) = %v, want %v", tt.input, result, tt.expected)
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &Options{OutputFormat: tt.outputFormat, GroupBy: tt.groupBy}
+			err := opts.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
 
-func TestSplitPath(t *testing.T) {
+func TestOptionsValidate_SubgraphBy(t *testing.T) {
 	tests := []struct {
-		name     string
-		path     string
-		expected []string
+		name         string
+		outputFormat string
+		subgraphBy   string
+		wantErr      bool
 	}{
-		{
-			name:     "simple path",
-			path:     "pod.metadata.name",
-			expected: []string{"pod", "metadata", "name"},
-		},
-		{
-			name:     "path with escaped dot",
-			path:     "node.metadata.labels.kubernetes\\.io/os",
-			expected: []string{"node", "metadata", "labels", "kubernetes.io/os"},
-		},
-		{
-			name:     "multiple escaped dots",
-			path:     "a\\.b.c\\.d.e",
-			expected: []string{"a.b", "c.d", "e"},
-		},
-		{
-			name:     "leading dot",
-			path:     ".pod.metadata.name",
-			expected: []string{"pod", "metadata", "name"},
-		},
-		{
-			name:     "trailing dot",
-			path:     "pod.metadata.",
-			expected: []string{"pod", "metadata"},
-		},
-		{
-			name:     "empty string",
-			path:     "",
-			expected: []string{},
-		},
+		{"dot by zone", "dot", "zone", false},
+		{"mermaid by namespace", "mermaid", "namespace", false},
+		{"table", "", "zone", true},
+		{"unknown grouping", "dot", "node", true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := splitPath(tt.path)
-			if len(result) != len(tt.expected) {
-				t.Errorf("splitPath(%q) length = %v, want %v", tt.path, len(result), len(tt.expected))
-				return
+			opts := &Options{OutputFormat: tt.outputFormat, SubgraphBy: tt.subgraphBy}
+			err := opts.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
 			}
-			for i := range result {
-				if result[i] != tt.expected[i] {
-					t.Errorf("splitPath(%q)[%d] = %v, want %v", tt.path, i, result[i], tt.expected[i])
-				}
+		})
+	}
+}
+
+func TestOptionsValidate_Report(t *testing.T) {
+	tests := []struct {
+		name         string
+		report       string
+		outputFormat string
+		containers   bool
+		wantErr      bool
+	}{
+		{"images table", "images", "", false, false},
+		{"images json", "images", "json", false, false},
+		{"topology table", "topology", "", false, false},
+		{"images markdown", "images", "markdown", false, true},
+		{"images jsonl", "images", "jsonl", false, true},
+		{"images wide", "images", "wide", false, true},
+		{"topology name", "topology", "name", false, true},
+		{"images resources", "images", "resources", false, true},
+		{"unknown report", "volumes", "", false, true},
+		{"custom columns", "images", "custom-columns=NAME:.pod.metadata.name", false, true},
+		{"containers", "images", "", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &Options{Report: tt.report, OutputFormat: tt.outputFormat, Containers: tt.containers}
+			err := opts.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
 
-func TestGetValueByPath(t *testing.T) {
-	// Create test pod
-	pod := &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      "test-pod",
-			Namespace: "default",
-			Labels: map[string]string{
-				"app": "myapp",
+// newTestCluster returns the objects of a small cluster with one node and
+// two pods in the default namespace, one of them mounting a PVC.
+func newTestCluster(nodeName string) []runtime.Object {
+	created := metav1.NewTime(time.Now().Add(-72 * time.Hour))
+	return []runtime.Object{
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   nodeName,
+				Labels: map[string]string{"kubernetes.io/os": "linux", corev1.LabelInstanceTypeStable: "m5.large"},
 			},
-			OwnerReferences: []metav1.OwnerReference{
-				{
-					Kind: "ReplicaSet",
-					Name: "test-rs",
+			Spec: corev1.NodeSpec{
+				Taints: []corev1.Taint{{Key: "dedicated", Value: "web", Effect: corev1.TaintEffectPreferNoSchedule}},
+			},
+			Status: corev1.NodeStatus{
+				Addresses: []corev1.NodeAddress{
+					{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+				},
+				Conditions: []corev1.NodeCondition{
+					{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+					{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionTrue},
+				},
+				Allocatable: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("4"),
+					corev1.ResourceMemory: resource.MustParse("8Gi"),
 				},
 			},
 		},
-		Spec: corev1.PodSpec{
-			NodeName:           "node1",
-			ServiceAccountName: "default",
+		&corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
 		},
-		Status: corev1.PodStatus{
-			Phase: corev1.PodRunning,
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "default"},
 		},
-	}
-
-	// Create test node
-	node := &corev1.Node{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: "node1",
-			Labels: map[string]string{
-				"kubernetes.io/os": "linux",
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "web-1",
+				Namespace:         "default",
+				Labels:            map[string]string{"app": "web"},
+				CreationTimestamp: created,
 			},
-		},
-		Status: corev1.NodeStatus{
-			NodeInfo: corev1.NodeSystemInfo{
-				OperatingSystem: "linux",
+			Spec: corev1.PodSpec{
+				NodeName:           nodeName,
+				ServiceAccountName: "web",
+				Containers: []corev1.Container{{
+					Name: "web",
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{
+							corev1.ResourceCPU:    resource.MustParse("500m"),
+							corev1.ResourceMemory: resource.MustParse("256Mi"),
+						},
+						Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("512Mi")},
+					},
+				}},
+				Volumes: []corev1.Volume{
+					{
+						Name: "data",
+						VolumeSource: corev1.VolumeSource{
+							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"},
+						},
+					},
+				},
+			},
+			Status: corev1.PodStatus{
+				Phase:             corev1.PodRunning,
+				ContainerStatuses: []corev1.ContainerStatus{{Name: "web", Ready: true, RestartCount: 2}},
 			},
 		},
-	}
-
-	// Create test ServiceAccount
-	sa := &corev1.ServiceAccount{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      "default",
-			Namespace: "default",
-		},
-	}
-
-	// Create test PVC
-	pvc := &corev1.PersistentVolumeClaim{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      "test-pvc",
-			Namespace: "default",
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "worker-1",
+				Namespace:         "default",
+				Labels:            map[string]string{"app": "worker"},
+				CreationTimestamp: created,
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "worker"}},
+			},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodPending,
+			},
 		},
 	}
+}
 
-	pn := PodWithWider{
-		Pod:            pod,
-		Node:           node,
-		ServiceAccount: sa,
-		PVCs:           []*corev1.PersistentVolumeClaim{pvc},
-	}
-
+func TestOptionsRun(t *testing.T) {
 	tests := []struct {
-		name     string
-		path     string
-		expected string
-		wantErr  bool
+		name          string
+		outputFormat  string
+		labelSelector string
+		allNamespaces bool
+		include       []string
+		containers    bool
+		groupBy       string
+		clusters      []string
+		want          string
+		contains      []string
+		excludes      []string
 	}{
 		{
-			name:     "pod name",
-			path:     ".pod.metadata.name",
-			expected: "test-pod",
-			wantErr:  false,
+			name:     "default table",
+			clusters: []string{"prod"},
+			want: `NAME       READY   STATUS    RESTARTS   AGE   IP         NODE
+web-1      1/1     Running   2          3d    10.0.0.1   node-a
+worker-1   0/1     Pending   0          3d
+`,
 		},
 		{
-			name:     "pod namespace",
-			path:     "pod.metadata.namespace",
-			expected: "default",
-			wantErr:  false,
+			name:          "default table with label selector",
+			labelSelector: "app=web",
+			clusters:      []string{"prod"},
+			want: `NAME    READY   STATUS    RESTARTS   AGE   IP         NODE
+web-1   1/1     Running   2          3d    10.0.0.1   node-a
+`,
 		},
 		{
-			name:     "pod label",
-			path:     "pod.metadata.labels.app",
-			expected: "myapp",
-			wantErr:  false,
+			name:          "all namespaces",
+			allNamespaces: true,
+			labelSelector: "app=web",
+			clusters:      []string{"prod"},
+			want: `NAMESPACE   NAME    READY   STATUS    RESTARTS   AGE   IP         NODE
+default     web-1   1/1     Running   2          3d    10.0.0.1   node-a
+`,
 		},
 		{
-			name:     "node name",
-			path:     ".node.metadata.name",
-			expected: "node1",
-			wantErr:  false,
+			name:          "multiple clusters",
+			labelSelector: "app=web",
+			clusters:      []string{"prod", "staging"},
+			want: `CLUSTER   NAME    READY   STATUS    RESTARTS   AGE   IP         NODE
+prod      web-1   1/1     Running   2          3d    10.0.0.1   node-a
+staging   web-1   1/1     Running   2          3d    10.0.0.1   node-b
+`,
 		},
 		{
-			name:     "node label with escaped dot",
-			path:     ".node.metadata.labels.kubernetes\\.io/os",
-			expected: "linux",
-			wantErr:  false,
+			name:         "custom columns",
+			outputFormat: "custom-columns=POD:.pod.metadata.name,NODE:.node.metadata.name,OS:.node.metadata.labels.kubernetes\\.io/os,SA:.sa.metadata.name,PVCS:.pvcs",
+			clusters:     []string{"prod"},
+			want: `POD        NODE     OS       SA       PVCS
+web-1      node-a   linux    web      data
+worker-1   <none>   <none>   <none>   <none>
+`,
 		},
 		{
-			name:     "node OS",
-			path:     ".node.status.nodeInfo.operatingSystem",
-			expected: "linux",
-			wantErr:  false,
+			name:       "containers",
+			containers: true,
+			clusters:   []string{"prod"},
+			want: `POD        CONTAINER   TYPE        IMAGE   READY   STATE    RESTARTS   LAST TERMINATION   NODE
+web-1      web         container           true    <none>   2          <none>             node-a
+worker-1   worker      container           false   <none>   0          <none>
+`,
 		},
 		{
-			name:     "service account name",
-			path:     ".serviceAccount.metadata.name",
-			expected: "default",
-			wantErr:  false,
+			name:         "wide",
+			outputFormat: "wide",
+			clusters:     []string{"prod"},
+			want: `NAME       READY   STATUS    RESTARTS   AGE   IP         NODE     NOMINATED NODE   READINESS GATES   OWNER    SERVICE ACCOUNT   PVCS     ZONE     INSTANCE TYPE   NODE STATUS   PRESSURE   TAINTS
+web-1      1/1     Running   2          3d    10.0.0.1   node-a   <none>           <none>            <none>   web               data     <none>   m5.large        Ready         Memory     dedicated=web:PreferNoSchedule
+worker-1   0/1     Pending   0          3d                        <none>           <none>            <none>   <none>            <none>   <none>   <none>          <none>        <none>     <none>
+`,
 		},
 		{
-			name:     "service account via sa alias",
-			path:     ".sa.metadata.name",
-			expected: "default",
-			wantErr:  false,
+			name:         "csv",
+			outputFormat: "csv=NAME:.pod.metadata.name,NODE:.node.metadata.name,OS:.node.metadata.labels.kubernetes\\.io/os",
+			clusters:     []string{"prod"},
+			want:         "NAME,NODE,OS\r\nweb-1,node-a,linux\r\nworker-1,<none>,<none>\r\n",
 		},
 		{
-			name:     "pvcs list",
-			path:     ".pvcs",
-			expected: "test-pvc",
-			wantErr:  false,
+			name:         "tsv",
+			outputFormat: "tsv=NAME:.pod.metadata.name,STATUS:.pod.status.phase",
+			clusters:     []string{"prod"},
+			want:         "NAME\tSTATUS\nweb-1\tRunning\nworker-1\tPending\n",
 		},
 		{
-			name:     "pod status phase",
-			path:     ".pod.status.phase",
-			expected: "Running",
-			wantErr:  false,
+			name:         "markdown",
+			outputFormat: "markdown",
+			clusters:     []string{"prod"},
+			want: `| NAME | READY | STATUS | RESTARTS | AGE | IP | NODE |
+| --- | --- | --- | --- | --- | --- | --- |
+| web-1 | 1/1 | Running | 2 | 3d | 10.0.0.1 | node-a |
+| worker-1 | 0/1 | Pending | 0 | 3d |  |  |
+`,
 		},
 		{
-			name:     "invalid path start",
-			path:     ".invalid.metadata.name",
-			expected: "",
-			wantErr:  true,
+			name:         "html grouped by status",
+			outputFormat: "html=NAME:.pod.metadata.name,TYPE:.node.metadata.labels.node\\.kubernetes\\.io/instance-type",
+			groupBy:      ".pod.status.phase",
+			clusters:     []string{"prod"},
+			want: `<h2>Pending</h2>
+<table>
+<thead>
+<tr><th>NAME</th><th>TYPE</th></tr>
+</thead>
+<tbody>
+<tr><td>worker-1</td><td>&lt;none&gt;</td></tr>
+</tbody>
+</table>
+
+<h2>Running</h2>
+<table>
+<thead>
+<tr><th>NAME</th><th>TYPE</th></tr>
+</thead>
+<tbody>
+<tr><td>web-1</td><td>m5.large</td></tr>
+</tbody>
+</table>
+`,
+		},
+		{
+			name:         "mermaid",
+			outputFormat: "mermaid",
+			clusters:     []string{"prod"},
+			want: `graph LR
+  v0["pod/default/web-1"]
+  v1["node/node-a"]
+  v2["serviceaccount/default/web"]
+  v3["pvc/default/data"]
+  v4["pod/default/worker-1"]
+  v0 -->|"runs on"| v1
+  v0 -->|"uses"| v2
+  v0 -->|"mounts"| v3
+`,
 		},
 		{
-			name:     "nonexistent field",
-			path:     ".pod.metadata.nonexistent",
-			expected: "",
-			wantErr:  true,
+			name:          "name",
+			outputFormat:  "name",
+			allNamespaces: true,
+			clusters:      []string{"prod"},
+			want: `pod/web-1
+pod/worker-1
+`,
+		},
+		{
+			name:         "resources",
+			outputFormat: "resources",
+			clusters:     []string{"prod"},
+			want: `NAME       CPU REQUESTS   CPU LIMITS   MEMORY REQUESTS   MEMORY LIMITS   EPHEMERAL REQUESTS   EPHEMERAL LIMITS   NODE     NODE CPU   NODE MEMORY   NODE EPHEMERAL
+web-1      500m (12%)     0 (0%)       256Mi (3%)        512Mi (6%)      0 (0%)               0 (0%)             node-a   4          8Gi           <none>
+worker-1   0              0            0                 0               0                    0                           <none>     <none>        <none>
+`,
+		},
+		{
+			name:         "json",
+			outputFormat: "json",
+			clusters:     []string{"prod"},
+			contains:     []string{`"name": "web-1"`, `"name": "worker-1"`, `"name": "node-a"`, `"name": "data"`},
+		},
+		{
+			name:         "yaml",
+			outputFormat: "yaml",
+			clusters:     []string{"prod"},
+			contains:     []string{"name: web-1", "name: worker-1", "name: node-a", "name: data"},
+		},
+		{
+			name:         "json with included relations",
+			outputFormat: "json",
+			include:      []string{"node"},
+			clusters:     []string{"prod"},
+			contains:     []string{`"name": "web-1"`, `"name": "node-a"`, `"serviceAccount": null`},
+			excludes:     []string{`"pvcs": [`},
+		},
+		{
+			name:          "custom columns with included relations",
+			outputFormat:  "custom-columns=POD:.pod.metadata.name",
+			include:       []string{"sa"},
+			labelSelector: "app=web",
+			clusters:      []string{"prod"},
+			want: `POD
+web-1
+`,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := getValueByPath(pn, tt.path)
-			if tt.wantErr {
-				if err == nil {
-					t.Errorf("getValueByPath(%q) expected error but got none", tt.path)
-				}
-				return
+			streams, _, out, _ := genericiooptions.NewTestIOStreams()
+			opts := NewWiderOptions(streams)
+			opts.OutputFormat = tt.outputFormat
+			opts.LabelSelector = tt.labelSelector
+			opts.AllNamespaces = tt.allNamespaces
+			opts.Include = tt.include
+			opts.Containers = tt.containers
+			opts.GroupBy = tt.groupBy
+			for i, name := range tt.clusters {
+				nodeName := "node-" + string(rune('a'+i))
+				opts.Clusters = append(opts.Clusters, Cluster{
+					Name:      name,
+					Namespace: "default",
+					Clientset: fake.NewClientset(newTestCluster(nodeName)...),
+				})
 			}
-			if err != nil {
-				t.Errorf("getValueByPath(%q) unexpected error: %v", tt.path, err)
-				return
+
+			if err := opts.Validate(); err != nil {
+				t.Fatalf("Validate() unexpected error: %v", err)
 			}
-			if result != tt.expected {
-				t.Errorf("getValueByPath(%q) = %v, want %v", tt.path, result, tt.expected)
+			if err := opts.Run(); err != nil {
+				t.Fatalf("Run() unexpected error: %v", err)
+			}
+
+			got := trimTrailingSpaces(out.String())
+			if tt.want != "" && got != tt.want {
+				t.Errorf("Run() output =\n%s\nwant\n%s", got, tt.want)
+			}
+			for _, c := range tt.contains {
+				if !strings.Contains(got, c) {
+					t.Errorf("Run() output does not contain %q:\n%s", c, got)
+				}
+			}
+			for _, c := range tt.excludes {
+				if strings.Contains(got, c) {
+					t.Errorf("Run() output unexpectedly contains %q:\n%s", c, got)
+				}
 			}
 		})
 	}
 }
 
-func TestGetValueByPath_NilNode(t *testing.T) {
-	pod := &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: "test-pod",
-		},
+func TestOptionsRun_ForbiddenNodes(t *testing.T) {
+	streams, _, out, errOut := genericiooptions.NewTestIOStreams()
+	opts := NewWiderOptions(streams)
+	opts.LabelSelector = "app=web"
+	client := fake.NewClientset(newTestCluster("node-a")...)
+	client.PrependReactor("*", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "nodes"}, "node-a", nil)
+	})
+	opts.Clusters = []Cluster{{Name: "prod", Namespace: "default", Clientset: client}}
+
+	if err := opts.Run(); err != nil {
+		t.Fatalf("Run() unexpected error: %v", err)
+	}
+	want := `NAME    READY   STATUS    RESTARTS   AGE   IP            NODE
+web-1   1/1     Running   2          3d    <forbidden>   node-a
+`
+	if got := out.String(); got != want {
+		t.Errorf("Run() output =\n%s\nwant\n%s", got, want)
+	}
+	if !strings.HasPrefix(errOut.String(), "Warning: node is forbidden") || strings.Count(errOut.String(), "\n") != 1 {
+		t.Errorf("expected a single warning, got %q", errOut.String())
 	}
 
-	pn := PodWithWider{
-		Pod:  pod,
-		Node: nil, // Node not assigned yet
+	out.Reset()
+	opts.Strict = true
+	if err := opts.Run(); !apierrors.IsForbidden(err) {
+		t.Errorf("Run() with --strict error = %v, want forbidden", err)
 	}
+}
 
-	result, err := getValueByPath(pn, ".node.metadata.name")
-	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+func TestOptionsCache(t *testing.T) {
+	streams, _, _, _ := genericiooptions.NewTestIOStreams()
+	opts := NewWiderOptions(streams)
+	cacheDir := t.TempDir()
+	opts.ConfigFlags.CacheDir = &cacheDir
+	opts.CacheTTL = time.Minute
+	cluster := Cluster{Name: "prod"}
+
+	if opts.cache(cluster) == nil {
+		t.Errorf("expected a cache with --cache-ttl set")
+	}
+	opts.NoCache = true
+	if opts.cache(cluster) != nil {
+		t.Errorf("expected no cache with --no-cache")
 	}
-	if result != "<none>" {
-		t.Errorf("expected <none> for nil node, got %v", result)
+	opts.NoCache = false
+	opts.CacheTTL = 0
+	if opts.cache(cluster) != nil {
+		t.Errorf("expected no cache with --cache-ttl 0")
 	}
 }
 
-func TestGetValueByPath_NilServiceAccount(t *testing.T) {
-	pod := &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: "test-pod",
-		},
+func TestOptionsRun_ImagesReport(t *testing.T) {
+	streams, _, out, _ := genericiooptions.NewTestIOStreams()
+	opts := NewWiderOptions(streams)
+	opts.Report = "images"
+	opts.OutputFormat = "json"
+	objects := newTestCluster("node-a")
+	for _, obj := range objects {
+		if pod, ok := obj.(*corev1.Pod); ok {
+			pod.Spec.Containers[0].Image = "nginx:1.27"
+		}
+		if node, ok := obj.(*corev1.Node); ok {
+			node.Status.Images = []corev1.ContainerImage{{Names: []string{"docker.io/library/nginx:1.27"}, SizeBytes: 1000}}
+		}
 	}
+	opts.Clusters = []Cluster{{Name: "prod", Namespace: "default", Clientset: fake.NewClientset(objects...)}}
 
-	pn := PodWithWider{
-		Pod:            pod,
-		ServiceAccount: nil,
+	if err := opts.Run(); err != nil {
+		t.Fatalf("Run() unexpected error: %v", err)
 	}
 
-	result, err := getValueByPath(pn, ".sa.metadata.name")
-	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+	var usages []wider.ImageUsage
+	if err := json.Unmarshal(out.Bytes(), &usages); err != nil {
+		t.Fatalf("failed to parse report: %v", err)
 	}
-	if result != "<none>" {
-		t.Errorf("expected <none> for nil service account, got %v", result)
+	if len(usages) != 1 || usages[0].Pods != 2 || !reflect.DeepEqual(usages[0].PresentOn, []string{"node-a"}) || usages[0].SizeBytes != 1000 {
+		t.Errorf("unexpected report %+v", usages)
 	}
 }
 
-func TestGetValueByPath_EmptyPVCs(t *testing.T) {
-	pod := &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: "test-pod",
-		},
+func TestOptionsRun_TopologyReport(t *testing.T) {
+	streams, _, out, _ := genericiooptions.NewTestIOStreams()
+	opts := NewWiderOptions(streams)
+	opts.Report = "topology"
+	objects := newTestCluster("node-a")
+	for _, obj := range objects {
+		if node, ok := obj.(*corev1.Node); ok {
+			node.Labels[corev1.LabelTopologyZone] = "eu-west-1a"
+		}
 	}
+	opts.Clusters = []Cluster{{Name: "prod", Namespace: "default", Clientset: fake.NewClientset(objects...)}}
 
-	pn := PodWithWider{
-		Pod:  pod,
-		PVCs: nil,
+	if err := opts.Run(); err != nil {
+		t.Fatalf("Run() unexpected error: %v", err)
 	}
 
-	result, err := getValueByPath(pn, ".pvcs")
-	if err != nil {
-		t.Errorf("unexpected error: %v", err)
-	}
-	if result != "<none>" {
-		t.Errorf("expected <none> for empty PVCs, got %v", result)
+	expected := `NAMESPACE   WORKLOAD       PODS                ZONES          REGIONS    NODES   FLAGS
+default     Pod/web-1      1                   eu-west-1a=1   <none>=1   1       <none>
+default     Pod/worker-1   1 (1 unscheduled)   <none>         <none>     0       <none>
+`
+	if got := out.String(); got != expected {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", got, expected)
 	}
 }
 
-func TestFindFieldByJSONTag(t *testing.T) {
-	pod := &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: "test-pod",
+func TestOptionsRun_Explain(t *testing.T) {
+	streams, _, out, _ := genericiooptions.NewTestIOStreams()
+	opts := NewWiderOptions(streams)
+	opts.Explain = true
+	objects := append(newTestCluster("node-a"), &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "batch-1", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			NodeSelector: map[string]string{"kubernetes.io/os": "linux"},
+			Containers: []corev1.Container{{
+				Name: "batch",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("8Gi")},
+				},
+			}},
 		},
+		Status: corev1.PodStatus{Phase: corev1.PodPending},
+	})
+	client := fake.NewClientset(objects...)
+	opts.Clusters = []Cluster{{Name: "prod", Namespace: "default", Clientset: client}}
+
+	if err := opts.Run(); err != nil {
+		t.Fatalf("Run() unexpected error: %v", err)
 	}
 
-	val := findFieldByJSONTag(reflect.ValueOf(*pod), "metadata")
-	if !val.IsValid() {
-		t.Error("expected to find 'metadata' field by JSON tag")
+	// Explain lists the nodes itself, so enriching the pods loads no relation
+	var resources []string
+	for _, action := range client.Actions() {
+		resources = append(resources, action.GetVerb()+" "+action.GetResource().Resource)
+	}
+	if want := []string{"list pods", "list nodes", "list pods"}; !reflect.DeepEqual(resources, want) {
+		t.Errorf("Run() made calls %v, want %v", resources, want)
 	}
 
-	val = findFieldByJSONTag(reflect.ValueOf(*pod), "nonexistent")
-	if val.IsValid() {
-		t.Error("expected not to find 'nonexistent' field")
+	expected := `POD        NODE     FITS    REASONS
+batch-1    node-a   false   insufficient memory (requested 8Gi, free 7936Mi of 8Gi)
+worker-1   node-a   true    <none>
+`
+	if got := out.String(); got != expected {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", got, expected)
+	}
+
+	if err := (&Options{Explain: true, Containers: true}).Validate(); err == nil {
+		t.Errorf("expected --explain with --containers to be rejected")
+	}
+	for _, format := range []string{"wide", "name", "resources", "custom-columns=NAME:.pod.metadata.name"} {
+		if err := (&Options{Explain: true, OutputFormat: format}).Validate(); err == nil {
+			t.Errorf("expected --explain with -o %s to be rejected", format)
+		}
+	}
+	if err := (&Options{Explain: true, OutputFormat: "yaml"}).Validate(); err != nil {
+		t.Errorf("expected --explain with -o yaml to be accepted, got %v", err)
 	}
 }
 
-func TestOptionsValidate(t *testing.T) {
-	tests := []struct {
-		name         string
-		outputFormat string
-		wantErr      bool
-	}{
-		{
-			name:         "empty format",
-			outputFormat: "",
-			wantErr:      false,
-		},
-		{
-			name:         "valid custom-columns",
-			outputFormat: "custom-columns=NAME:.pod.metadata.name",
-			wantErr:      false,
-		},
-		{
-			name:         "invalid format",
-			outputFormat: "json",
-			wantErr:      true,
-		},
-		{
-			name:         "invalid format yaml",
-			outputFormat: "yaml",
-			wantErr:      true,
-		},
+func TestOptionsRun_UnknownInclude(t *testing.T) {
+	streams, _, _, _ := genericiooptions.NewTestIOStreams()
+	opts := NewWiderOptions(streams)
+	opts.Include = []string{"nodes"}
+	opts.Clusters = []Cluster{{Name: "prod", Namespace: "default", Clientset: fake.NewClientset()}}
+
+	err := opts.Run()
+	if err == nil || !strings.Contains(err.Error(), "unknown relation: nodes") {
+		t.Errorf("Run() error = %v, want unknown relation", err)
 	}
+}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			opts := &Options{
-				OutputFormat: tt.outputFormat,
+// trimTrailingSpaces strips the padding tabwriter leaves after empty trailing cells.
+func trimTrailingSpaces(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestOptionsRun_StructuredOutputParses(t *testing.T) {
+	for _, format := range []string{"json", "yaml"} {
+		t.Run(format, func(t *testing.T) {
+			streams, _, out, _ := genericiooptions.NewTestIOStreams()
+			opts := NewWiderOptions(streams)
+			opts.OutputFormat = format
+			opts.Clusters = []Cluster{{
+				Name:      "prod",
+				Namespace: "default",
+				Clientset: fake.NewClientset(newTestCluster("node-a")...),
+			}}
+
+			if err := opts.Run(); err != nil {
+				t.Fatalf("Run() unexpected error: %v", err)
 			}
-			err := opts.Validate()
-			if tt.wantErr && err == nil {
-				t.Error("expected error but got none")
+
+			var list wider.PodWithWiderList
+			var err error
+			if format == "json" {
+				err = json.Unmarshal(out.Bytes(), &list)
+			} else {
+				err = yaml.Unmarshal(out.Bytes(), &list)
 			}
-			if !tt.wantErr && err != nil {
-				t.Errorf("unexpected error: %v", err)
+			if err != nil {
+				t.Fatalf("failed to parse %s output: %v", format, err)
+			}
+			if list.APIVersion != wider.APIVersion || list.Kind != wider.ListKind {
+				t.Errorf("expected a %s %s, got %s %s", wider.APIVersion, wider.ListKind, list.APIVersion, list.Kind)
+			}
+			pods := list.Items
+			if len(pods) != 2 {
+				t.Fatalf("expected 2 pods, got %d", len(pods))
+			}
+			if pods[0].Node == nil || pods[0].Node.Name != "node-a" {
+				t.Errorf("expected web-1 to be joined with node-a, got %v", pods[0].Node)
+			}
+			if pods[0].ServiceAccount == nil || pods[0].ServiceAccount.Name != "web" {
+				t.Errorf("expected web-1 to be joined with service account web, got %v", pods[0].ServiceAccount)
+			}
+			if len(pods[0].PVCs) != 1 || pods[0].PVCs[0].Name != "data" {
+				t.Errorf("expected web-1 to be joined with PVC data, got %v", pods[0].PVCs)
 			}
 		})
 	}
 }
+
+func TestOptionsRun_JSONLines(t *testing.T) {
+	streams, _, out, _ := genericiooptions.NewTestIOStreams()
+	opts := NewWiderOptions(streams)
+	opts.OutputFormat = "jsonl"
+	opts.ChunkSize = 1
+	opts.Clusters = []Cluster{{
+		Name:      "prod",
+		Namespace: "default",
+		Clientset: fake.NewClientset(newTestCluster("node-a")...),
+	}}
+
+	if err := opts.Run(); err != nil {
+		t.Fatalf("Run() unexpected error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a line per pod, got %d:\n%s", len(lines), out.String())
+	}
+	var pn wider.PodWithWider
+	if err := json.Unmarshal([]byte(lines[0]), &pn); err != nil {
+		t.Fatalf("failed to parse line %q: %v", lines[0], err)
+	}
+	if pn.Pod.Name != "web-1" || pn.Node == nil || pn.Node.Name != "node-a" || pn.ServiceAccount == nil {
+		t.Errorf("expected web-1 joined with every relation, got %+v", pn)
+	}
+}
@@ -3,64 +3,225 @@ package main
 import (
 	"context"
 	"fmt"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/tools/clientcmd"
-	"strings"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/spf13/cobra"
-	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/wider-cli-plugin/pkg/wider"
 )
 
-type PodWithWider struct {
-	Pod            *corev1.Pod
-	Node           *corev1.Node
-	ServiceAccount *corev1.ServiceAccount
-	PVCs           []*corev1.PersistentVolumeClaim
+// Cluster is a kubeconfig context queried by the plugin.
+type Cluster struct {
+	Name      string
+	Namespace string
+	Clientset kubernetes.Interface
+	Dynamic   dynamic.Interface
+	Metadata  metadata.Interface
 }
 
 type Options struct {
-	Namespace     string
 	OutputFormat  string
 	LabelSelector string
 	AllNamespaces bool
-	Clientset     *kubernetes.Clientset
-	ConfigFlags   *clientcmd.ClientConfigLoadingRules
+	Contexts      []string
+	AllContexts   bool
+	Clusters      []Cluster
+	ConfigFlags   *genericclioptions.ConfigFlags
+	// RelationsConfig is a file declaring custom resource relations.
+	RelationsConfig string
+	Registry        *wider.Registry
+	// Include forces relations on by name or root, e.g. for json and yaml output.
+	Include      []string
+	Concurrency  int
+	ChunkSize    int64
+	GetThreshold int
+	// CacheTTL is how long cached node and PersistentVolume lists are reused, see --no-cache.
+	CacheTTL time.Duration
+	NoCache  bool
+	// Containers prints one row per container instead of one per pod.
+	Containers bool
+	// Report aggregates the pods into a report instead of listing them, see wider.ValidateReport.
+	Report string
+	// Strict fails when a related resource is forbidden or not served instead of printing partial results.
+	Strict bool
+	// Explain evaluates pending pods against every node instead of listing pods.
+	Explain bool
+	// GroupBy is a path splitting markdown and html output into a section per value.
+	GroupBy string
+	// SubgraphBy groups dot and mermaid output by namespace or zone.
+	SubgraphBy string
+
+	genericiooptions.IOStreams
+	// warnMu serializes warnings written by concurrently queried clusters.
+	warnMu sync.Mutex
 }
 
 func (o *Options) Complete() error {
-	configOverrides := &clientcmd.ConfigOverrides{}
-	kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(o.ConfigFlags, configOverrides)
+	if err := o.completeRegistry(); err != nil {
+		return err
+	}
 
-	config, err := kubeConfig.ClientConfig()
+	raw, err := o.ConfigFlags.ToRawKubeConfigLoader().RawConfig()
 	if err != nil {
 		return fmt.Errorf("failed to load kubeconfig: %w", err)
 	}
 
-	o.Clientset, err = kubernetes.NewForConfig(config)
-	if err != nil {
-		return fmt.Errorf("failed to create clientset: %w", err)
+	contexts := o.Contexts
+	if o.AllContexts {
+		contexts = nil
+		for name := range raw.Contexts {
+			contexts = append(contexts, name)
+		}
+		sort.Strings(contexts)
 	}
 
-	// Get current namespace if not specified
-	if o.Namespace == "" && !o.AllNamespaces {
-		o.Namespace, _, err = kubeConfig.Namespace()
+	o.Clusters = nil
+	if len(contexts) == 0 {
+		contextName := raw.CurrentContext
+		if o.ConfigFlags.Context != nil && *o.ConfigFlags.Context != "" {
+			contextName = *o.ConfigFlags.Context
+		}
+		cluster, err := o.newCluster(contextName, o.ConfigFlags)
 		if err != nil {
-			return fmt.Errorf("failed to get current namespace: %w", err)
+			return err
 		}
+		o.Clusters = append(o.Clusters, cluster)
+		return nil
+	}
+
+	for _, name := range contexts {
+		cluster, err := o.newCluster(name, o.configFlagsForContext(name))
+		if err != nil {
+			return err
+		}
+		o.Clusters = append(o.Clusters, cluster)
 	}
 
 	return nil
 }
 
-func NewWiderOptions() *Options {
+// completeRegistry adds the custom relations from the relations config to the built-in ones.
+func (o *Options) completeRegistry() error {
+	o.Registry = wider.NewRegistry(wider.DefaultRegistry.Relations()...)
+
+	path := o.RelationsConfig
+	if path == "" {
+		// Fall back to ~/.kube/wider.yaml when present
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(home, ".kube", "wider.yaml")
+		if _, err := os.Stat(path); err != nil {
+			return nil
+		}
+	}
+
+	relations, err := wider.LoadRelationsConfig(path)
+	if err != nil {
+		return err
+	}
+	for _, rel := range relations {
+		for _, root := range rel.Roots() {
+			if _, ok := o.Registry.Lookup(root); ok {
+				return fmt.Errorf("relation %s: root %s is already in use", rel.Name(), root)
+			}
+		}
+		o.Registry.Register(rel)
+	}
+	return nil
+}
+
+// configFlagsForContext copies the connection flags, pointing them at another context.
+func (o *Options) configFlagsForContext(contextName string) *genericclioptions.ConfigFlags {
+	flags := o.ConfigFlags
+	return &genericclioptions.ConfigFlags{
+		CacheDir:           flags.CacheDir,
+		KubeConfig:         flags.KubeConfig,
+		ClusterName:        flags.ClusterName,
+		AuthInfoName:       flags.AuthInfoName,
+		Context:            &contextName,
+		Namespace:          flags.Namespace,
+		APIServer:          flags.APIServer,
+		TLSServerName:      flags.TLSServerName,
+		Insecure:           flags.Insecure,
+		CertFile:           flags.CertFile,
+		KeyFile:            flags.KeyFile,
+		CAFile:             flags.CAFile,
+		BearerToken:        flags.BearerToken,
+		Impersonate:        flags.Impersonate,
+		ImpersonateUID:     flags.ImpersonateUID,
+		ImpersonateGroup:   flags.ImpersonateGroup,
+		Username:           flags.Username,
+		Password:           flags.Password,
+		Timeout:            flags.Timeout,
+		DisableCompression: flags.DisableCompression,
+		WrapConfigFn:       flags.WrapConfigFn,
+	}
+}
+
+func (o *Options) newCluster(contextName string, flags *genericclioptions.ConfigFlags) (Cluster, error) {
+	config, err := flags.ToRESTConfig()
+	if err != nil {
+		return Cluster{}, fmt.Errorf("failed to load kubeconfig for context %q: %w", contextName, err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return Cluster{}, fmt.Errorf("failed to create clientset for context %q: %w", contextName, err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return Cluster{}, fmt.Errorf("failed to create dynamic client for context %q: %w", contextName, err)
+	}
+
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		return Cluster{}, fmt.Errorf("failed to create metadata client for context %q: %w", contextName, err)
+	}
+
+	// Get the context namespace unless querying all namespaces; this
+	// honours -n/--namespace through the config flags
+	namespace := ""
+	if !o.AllNamespaces {
+		namespace, _, err = flags.ToRawKubeConfigLoader().Namespace()
+		if err != nil {
+			return Cluster{}, fmt.Errorf("failed to get current namespace: %w", err)
+		}
+	}
+
+	return Cluster{
+		Name:      contextName,
+		Namespace: namespace,
+		Clientset: clientset,
+		Dynamic:   dynamicClient,
+		Metadata:  metadataClient,
+	}, nil
+}
+
+// multiCluster reports whether results come from more than one context.
+func (o *Options) multiCluster() bool {
+	return len(o.Clusters) > 1
+}
+
+func NewWiderOptions(streams genericiooptions.IOStreams) *Options {
 	return &Options{
-		ConfigFlags: clientcmd.NewDefaultClientConfigLoadingRules(),
+		ConfigFlags: genericclioptions.NewConfigFlags(true),
+		IOStreams:   streams,
 	}
 }
 
-func NewRootCommand() *cobra.Command {
-	opts := NewWiderOptions()
+func NewRootCommand(streams genericiooptions.IOStreams) *cobra.Command {
+	opts := NewWiderOptions(streams)
 
 	cmd := &cobra.Command{
 		Use:   "kubectl-wider",
@@ -73,6 +234,9 @@ Examples:
   
   # List pods in specific namespace
   kubectl wider -n kube-system
+
+  # Use another context or impersonate a user, like any kubectl command
+  kubectl wider --context staging --as jane --as-group developers
   
   # List pods in all namespaces
   kubectl wider -A
@@ -83,16 +247,66 @@ Examples:
 
   # Combine label selector with namespace
   kubectl wider -n default -l app=nginx
+
+  # Compare pods across several kubeconfig contexts
+  kubectl wider --contexts prod-eu,prod-us -l app=nginx
+  kubectl wider --all-contexts -o custom-columns=CLUSTER:.cluster,NAME:.pod.metadata.name,NODE:.node.metadata.name
 	
   # Custom columns output
   kubectl wider -o custom-columns=NAME:.pod.metadata.name,NODE:.node.metadata.name,OS:.node.metadata.labels.kubernetes\.io/os
 	
+  # Owner, service account, claims, node zone, instance type, readiness, pressure and taints next to every pod
+  kubectl wider -o wide
+
+  # Pod names for shell pipelines, e.g. delete the pods labeled app=web in the current namespace
+  kubectl wider -o name -l app=web | xargs kubectl delete
+
+  # One row per container with its image, state and limits
+  kubectl wider --containers
+  kubectl wider --containers -o custom-columns=POD:.pod.metadata.name,CONTAINER:.container.name,CPU:.container.resources.limits.cpu,REASON:.container.status.lastState.terminated.reason,NODE:.node.metadata.name
+
+  # Requests and limits against the node allocatable
+  kubectl wider -o resources
+  kubectl wider -o custom-columns=NAME:.pod.metadata.name,CPU:.resources.requests.cpu,NODE%:.resources.requestsPercent.cpu,NODE:.node.metadata.name
+
+  # Which images run where, with digests and node image cache presence
+  kubectl wider -A --report images
+  kubectl wider -A --report images -o json
+
+  # How the replicas of every workload spread across zones and nodes
+  kubectl wider -A --report topology
+
+  # Why pending pods cannot be scheduled, node by node
+  kubectl wider --explain
+  kubectl wider --explain -o yaml
+
+  # Custom resource relations declared in ~/.kube/wider.yaml
+  kubectl wider -o custom-columns=NAME:.pod.metadata.name,VPA:.vpa.metadata.name,MODE:.vpa.spec.updatePolicy.updateMode
+
   # JSON output
   kubectl wider -o json
   
   # YAML output
   kubectl wider -o yaml
 
+  # Spreadsheet friendly columns, quoted when values contain commas or quotes
+  kubectl wider -A -o csv=NAMESPACE:.pod.metadata.namespace,NAME:.pod.metadata.name,CPU:.resources.requests.cpu,NODE:.node.metadata.name > pods.csv
+  kubectl wider -o tsv=NAME:.pod.metadata.name,NODE:.node.metadata.name
+
+  # Tables to paste into documents, optionally with a section per zone
+  kubectl wider -o markdown
+  kubectl wider -A -o html=NAMESPACE:.pod.metadata.namespace,NAME:.pod.metadata.name,NODE:.node.metadata.name --group-by .node.metadata.labels.topology\.kubernetes\.io/zone
+
+  # Graph of pods, nodes, ServiceAccounts, PVCs and PVs, rendered with Graphviz or Mermaid
+  kubectl wider -l app=web -o dot --subgraph-by zone | dot -Tsvg > web.svg
+  kubectl wider -A -o mermaid --subgraph-by namespace
+
+  # One JSON document per pod and line, written as pages arrive
+  kubectl wider -A -o jsonl | jq -c '{name: .pod.metadata.name, node: .node.metadata.name}'
+
+  # JSON output with the node only
+  kubectl wider -o json --include node
+
   More information is available at the project website:
   https://github.com/boriscosic/wider`,
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -106,160 +320,272 @@ Examples:
 		},
 	}
 
-	cmd.Flags().StringVarP(&opts.Namespace, "namespace", "n", "", "Namespace to query (defaults to current context namespace)")
-	cmd.Flags().StringVarP(&opts.OutputFormat, "output", "o", "", "Output format. One of: (json, yaml, custom-columns) (e.g., custom-columns=\"NAME:.pod.metadata.name,NODE:.node.metadata.name,OS:.node.metadata.labels.kubernetes\\.io/os\")")
+	cmd.Flags().StringVarP(&opts.OutputFormat, "output", "o", "", "Output format. One of: (json, yaml, jsonl, ndjson, wide, name, resources, markdown, html, dot, mermaid, custom-columns, csv, tsv) (e.g., custom-columns=\"NAME:.pod.metadata.name,NODE:.node.metadata.name,OS:.node.metadata.labels.kubernetes\\.io/os\")")
 	cmd.Flags().BoolVarP(&opts.AllNamespaces, "all-namespaces", "A", false, "Query all namespaces")
 	cmd.Flags().StringVarP(&opts.LabelSelector, "selector", "l", "", "Selector (label query) to filter on, supports '=', '==', and '!='.(e.g. -l key1=value1,key2=value2)")
+	cmd.Flags().StringSliceVar(&opts.Contexts, "contexts", nil, "Comma separated kubeconfig contexts to query concurrently (e.g. --contexts ctx1,ctx2)")
+	cmd.Flags().BoolVar(&opts.AllContexts, "all-contexts", false, "Query every context in the kubeconfig concurrently")
+	cmd.Flags().IntVar(&opts.Concurrency, "concurrency", wider.DefaultConcurrency, "Maximum number of API calls made in parallel per cluster")
+	cmd.Flags().Int64Var(&opts.ChunkSize, "chunk-size", wider.DefaultChunkSize, "Return large lists in chunks rather than all at once. Pass 0 to disable.")
+	cmd.Flags().IntVar(&opts.GetThreshold, "get-threshold", wider.DefaultGetThreshold, "Fetch related objects one by one while at most this many are referenced, otherwise list them. Pass a negative value to always list.")
+	cmd.Flags().DurationVar(&opts.CacheTTL, "cache-ttl", wider.DefaultCacheTTL, "How long node and PersistentVolume lists cached under --cache-dir are reused, changed objects are refreshed on every run. Pass 0 to disable.")
+	cmd.Flags().BoolVar(&opts.NoCache, "no-cache", false, "Do not read or write the node and PersistentVolume cache")
+	cmd.Flags().BoolVar(&opts.Strict, "strict", false, "Fail when a related resource is forbidden or not served by the cluster instead of leaving it out")
+	cmd.Flags().BoolVar(&opts.Containers, "containers", false, "Print one row per init, regular and ephemeral container, exposed as .container in custom-columns")
+	cmd.Flags().StringVar(&opts.Report, "report", "", "Aggregate the selected pods into a report instead of listing them. One of: (images, topology)")
+	cmd.Flags().BoolVar(&opts.Explain, "explain", false, "Explain why pending pods do not fit each node: node selector, node affinity, taints and free resources")
+	cmd.Flags().StringVar(&opts.GroupBy, "group-by", "", "Path splitting markdown and html output into a section per value (e.g. --group-by .node.metadata.labels.topology\\.kubernetes\\.io/zone)")
+	cmd.Flags().StringVar(&opts.SubgraphBy, "subgraph-by", "", "Group dot and mermaid output into subgraphs. One of: (namespace, zone)")
+	cmd.Flags().StringSliceVar(&opts.Include, "include", nil, "Relations to load regardless of the output format, by name or root (e.g. --include node,sa). With json and yaml only these are loaded.")
+	cmd.Flags().StringVar(&opts.RelationsConfig, "relations-config", "", "File declaring custom resource relations (defaults to ~/.kube/wider.yaml when present)")
+	opts.ConfigFlags.AddFlags(cmd.Flags())
 
 	return cmd
 }
 
 func (o *Options) Validate() error {
-	if o.OutputFormat != "" {
-		isValid := false
+	if o.AllContexts && len(o.Contexts) > 0 {
+		return fmt.Errorf("--contexts and --all-contexts cannot be used together")
+	}
+	if o.Concurrency < 0 {
+		return fmt.Errorf("--concurrency must not be negative")
+	}
+	if o.ChunkSize < 0 {
+		return fmt.Errorf("--chunk-size must not be negative")
+	}
+	if o.CacheTTL < 0 {
+		return fmt.Errorf("--cache-ttl must not be negative")
+	}
+	if (o.AllContexts || len(o.Contexts) > 0) && o.ConfigFlags != nil && o.ConfigFlags.Context != nil && *o.ConfigFlags.Context != "" {
+		return fmt.Errorf("--context cannot be combined with --contexts or --all-contexts")
+	}
 
-		if o.OutputFormat == "json" || o.OutputFormat == "yaml" {
-			isValid = true
-		} else if strings.HasPrefix(o.OutputFormat, "custom-columns=") {
-			isValid = true
+	if o.Containers && (o.OutputFormat == "resources" || o.OutputFormat == "wide" || o.OutputFormat == "name" || wider.IsDocumentOutput(o.OutputFormat) || wider.IsGraphOutput(o.OutputFormat)) {
+		return fmt.Errorf("--containers cannot be combined with -o %s", o.OutputFormat)
+	}
+	if err := wider.ValidateReport(o.Report); err != nil {
+		return err
+	}
+	if o.Report != "" && o.Containers {
+		return fmt.Errorf("--report cannot be combined with --containers")
+	}
+	if o.Explain && (o.Report != "" || o.Containers) {
+		return fmt.Errorf("--explain cannot be combined with --report or --containers")
+	}
+	if (o.Report != "" || o.Explain) && o.OutputFormat != "" && o.OutputFormat != "json" && o.OutputFormat != "yaml" {
+		flag := "--report"
+		if o.Explain {
+			flag = "--explain"
 		}
-
-		if !isValid {
-			return fmt.Errorf("unsupported output format: %s (supported: json, yaml, custom-columns=...)", o.OutputFormat)
+		return fmt.Errorf("%s only supports the default table, json or yaml output, not -o %s", flag, o.OutputFormat)
+	}
+	if err := wider.ValidateSubgraphBy(o.SubgraphBy); err != nil {
+		return err
+	}
+	if o.SubgraphBy != "" && !wider.IsGraphOutput(o.OutputFormat) {
+		return fmt.Errorf("--subgraph-by requires dot or mermaid output")
+	}
+	if o.GroupBy != "" {
+		if !wider.IsDocumentOutput(o.OutputFormat) {
+			return fmt.Errorf("--group-by requires markdown or html output")
+		}
+		if _, err := wider.ParsePath(o.GroupBy); err != nil {
+			return fmt.Errorf("invalid --group-by path %q: %w", o.GroupBy, err)
 		}
 	}
-	return nil
+
+	return wider.ValidateOutputFormat(o.OutputFormat)
 }
 
 func (o *Options) Run() error {
 	ctx := context.Background()
 
-	// Set namespace for API call
-	ns := o.Namespace
-	if o.AllNamespaces {
-		ns = ""
-	}
-
-	needsSA := false
-	needsPVC := false
-
-	if o.OutputFormat == "json" || o.OutputFormat == "yaml" {
-		needsPVC = true
-		needsSA = true
+	include := o.Include
+	if o.Report != "" {
+		include = append(wider.ReportRelations(o.Report), include...)
 	}
-
-	nodeMap := make(map[string]*corev1.Node)
-	saMap := make(map[string]*corev1.ServiceAccount)
-	pvcMap := make(map[string]*corev1.PersistentVolumeClaim)
-
-	if strings.Contains(o.OutputFormat, ".sa") || strings.Contains(o.OutputFormat, ".serviceAccount") {
-		needsSA = true
+	if o.GroupBy != "" {
+		path, err := wider.ParsePath(o.GroupBy)
+		if err != nil {
+			return err
+		}
+		include = append(include, o.registry().RelationsForPaths([]wider.Path{path})...)
 	}
-
-	if strings.Contains(o.OutputFormat, ".pvc") || strings.Contains(o.OutputFormat, ".pvcs") {
-		needsPVC = true
+	outputFormat := o.OutputFormat
+	if o.Explain {
+		// Explain lists the nodes itself and prints the pods without their
+		// relations, so only load those asked for with --include
+		outputFormat = "name"
 	}
-
-	// Get pods
-	pods, err := o.Clientset.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{
-		LabelSelector: o.LabelSelector,
-	})
+	relations, err := o.registry().RelationsFor(outputFormat, include)
 	if err != nil {
-		return fmt.Errorf("failed to list pods: %w", err)
+		return err
 	}
 
-	// Get nodes
-	nodes, err := o.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to list nodes: %w", err)
+	printOpts := wider.PrintOptions{
+		OutputFormat:  o.OutputFormat,
+		AllNamespaces: o.AllNamespaces,
+		ShowCluster:   o.multiCluster(),
+		Containers:    o.Containers,
+		Registry:      o.registry(),
+		GroupBy:       o.GroupBy,
+		SubgraphBy:    o.SubgraphBy,
 	}
 
-	// Create node map for quick lookup
-	for i := range nodes.Items {
-		nodeMap[nodes.Items[i].Name] = &nodes.Items[i]
+	// Reports aggregate every pod before printing
+	if o.Report != "" {
+		podNodes, err := o.enrichAll(ctx, relations)
+		if err != nil {
+			return err
+		}
+		return wider.PrintReport(o.Out, o.Report, printOpts, podNodes)
 	}
 
-	if needsPVC {
-		// Get all PVCs if needed
-		allPVCs, err := o.Clientset.CoreV1().PersistentVolumeClaims(ns).List(ctx, metav1.ListOptions{})
+	if o.Explain {
+		explanations, err := o.explainAll(ctx, relations)
 		if err != nil {
-			return fmt.Errorf("failed to list PVCs: %w", err)
+			return err
 		}
+		return wider.PrintExplanations(o.Out, printOpts, explanations)
+	}
 
-		// Create PVC map for quick lookup (namespace/name -> PVC)
-		for i := range allPVCs.Items {
-			key := allPVCs.Items[i].Namespace + "/" + allPVCs.Items[i].Name
-			pvcMap[key] = &allPVCs.Items[i]
+	printer, err := wider.NewPrinter(o.Out, printOpts)
+	if err != nil {
+		return err
+	}
+	printPage := printer.PrintPage
+	if o.Containers {
+		printPage = func(podNodes []wider.PodWithWider) error {
+			return printer.PrintPage(wider.ExpandContainers(podNodes))
 		}
 	}
 
-	if needsSA {
-		// Get all ServiceAccounts if needed
-		allSAs, err := o.Clientset.CoreV1().ServiceAccounts(ns).List(ctx, metav1.ListOptions{})
-		if err != nil {
-			return fmt.Errorf("failed to list ServiceAccounts: %w", err)
+	// A single cluster streams pages to the printer as they arrive
+	if !o.multiCluster() {
+		for _, cluster := range o.Clusters {
+			if err := o.enricher(cluster, relations).Stream(ctx, printPage); err != nil {
+				return err
+			}
 		}
+		return printer.Flush()
+	}
 
-		// Create ServiceAccount map for quick lookup (namespace/name -> SA)
-		for i := range allSAs.Items {
-			key := allSAs.Items[i].Namespace + "/" + allSAs.Items[i].Name
-			saMap[key] = &allSAs.Items[i]
-		}
+	podNodes, err := o.enrichAll(ctx, relations)
+	if err != nil {
+		return err
+	}
+	if err := printPage(podNodes); err != nil {
+		return err
+	}
+	return printer.Flush()
+}
+
+// enrichAll queries every cluster concurrently, keeping results in context order.
+func (o *Options) enrichAll(ctx context.Context, relations []string) ([]wider.PodWithWider, error) {
+	return forEachCluster(o, func(cluster Cluster) ([]wider.PodWithWider, error) {
+		return o.enricher(cluster, relations).Enrich(ctx)
+	})
+}
+
+// explainAll explains the pending pods of every cluster concurrently, keeping results in context order.
+func (o *Options) explainAll(ctx context.Context, relations []string) ([]wider.Explanation, error) {
+	return forEachCluster(o, func(cluster Cluster) ([]wider.Explanation, error) {
+		return o.enricher(cluster, relations).Explain(ctx)
+	})
+}
+
+// forEachCluster runs query against every cluster concurrently and
+// concatenates the results in context order.
+func forEachCluster[T any](o *Options, query func(Cluster) ([]T, error)) ([]T, error) {
+	results := make([][]T, len(o.Clusters))
+	errs := make([]error, len(o.Clusters))
+	var wg sync.WaitGroup
+	for i, cluster := range o.Clusters {
+		wg.Go(func() {
+			results[i], errs[i] = query(cluster)
+		})
 	}
+	wg.Wait()
 
-	// Build pod with node information
-	var podNodes []PodWithWider
-	for i := range pods.Items {
-		pod := &pods.Items[i]
-		node := nodeMap[pod.Spec.NodeName]
-
-		// Get ServiceAccount
-		var sa *corev1.ServiceAccount
-		if pod.Spec.ServiceAccountName != "" && len(saMap) > 0 {
-			saKey := pod.Namespace + "/" + pod.Spec.ServiceAccountName
-			sa = saMap[saKey]
-			// If not in map, try to fetch it directly
-			if sa == nil {
-				fetchedSA, err := o.Clientset.CoreV1().ServiceAccounts(pod.Namespace).Get(ctx, pod.Spec.ServiceAccountName, metav1.GetOptions{})
-				if err == nil {
-					sa = fetchedSA
-				}
+	var all []T
+	for i, err := range errs {
+		if err != nil {
+			if !o.multiCluster() {
+				return nil, err
 			}
+			return nil, fmt.Errorf("context %s: %w", o.Clusters[i].Name, err)
 		}
+		all = append(all, results[i]...)
+	}
+	return all, nil
+}
 
-		// Get PVCs for this pod
-		var podPVCs []*corev1.PersistentVolumeClaim
-		for _, vol := range pod.Spec.Volumes {
-			if vol.PersistentVolumeClaim != nil && len(pvcMap) > 0 {
-				pvcKey := pod.Namespace + "/" + vol.PersistentVolumeClaim.ClaimName
-				if pvc, ok := pvcMap[pvcKey]; ok {
-					podPVCs = append(podPVCs, pvc)
-				} else {
-					// If not in map, try to fetch it directly
-					fetchedPVC, err := o.Clientset.CoreV1().PersistentVolumeClaims(pod.Namespace).Get(ctx, vol.PersistentVolumeClaim.ClaimName, metav1.GetOptions{})
-					if err == nil {
-						podPVCs = append(podPVCs, fetchedPVC)
-					}
-				}
+// enricher returns the enricher for a single cluster.
+func (o *Options) enricher(cluster Cluster, relations []string) *wider.Enricher {
+	clients := wider.Clients{Kubernetes: cluster.Clientset, Dynamic: cluster.Dynamic, Metadata: cluster.Metadata}
+	return wider.NewEnricher(clients, o.enrichOptions(cluster, relations))
+}
+
+// enrichOptions returns the enrichment options loading relations for a single cluster.
+func (o *Options) enrichOptions(cluster Cluster, relations []string) wider.Options {
+	opts := wider.Options{
+		Cluster:       cluster.Name,
+		Namespace:     cluster.Namespace,
+		LabelSelector: o.LabelSelector,
+		Relations:     relations,
+		MetadataOnly:  o.metadataOnly(),
+		Registry:      o.registry(),
+		Concurrency:   o.Concurrency,
+		ChunkSize:     o.ChunkSize,
+		GetThreshold:  o.GetThreshold,
+		Cache:         o.cache(cluster),
+		Strict:        o.Strict,
+		Warn: func(message string) {
+			if o.multiCluster() {
+				message = fmt.Sprintf("context %s: %s", cluster.Name, message)
 			}
-		}
+			o.warnMu.Lock()
+			defer o.warnMu.Unlock()
+			fmt.Fprintf(o.ErrOut, "Warning: %s\n", message)
+		},
+	}
+	if o.AllNamespaces {
+		opts.Namespace = ""
+	}
+	return opts
+}
 
-		podNodes = append(podNodes, PodWithWider{
-			Pod:            pod,
-			Node:           node,
-			ServiceAccount: sa,
-			PVCs:           podPVCs,
-		})
+// metadataOnly returns the relations of which the output columns and
+// --group-by only read metadata.
+func (o *Options) metadataOnly() []string {
+	paths, err := wider.OutputPaths(o.OutputFormat)
+	if err != nil || len(paths) == 0 {
+		// Outputs without column paths read whole objects
+		return nil
+	}
+	if o.GroupBy != "" {
+		path, err := wider.ParsePath(o.GroupBy)
+		if err != nil {
+			return nil
+		}
+		paths = append(paths, path)
 	}
+	return o.registry().MetadataOnlyForPaths(paths)
+}
 
-	// Output
-	if strings.HasPrefix(o.OutputFormat, "custom-columns=") {
-		return o.printCustomColumns(podNodes)
-	} else if o.OutputFormat == "json" {
-		return o.printJSON(podNodes)
-	} else if o.OutputFormat == "yaml" {
-		return o.printYAML(podNodes)
+// cache returns the on-disk cache of a cluster, kept under the kubectl
+// --cache-dir, or nil when caching is disabled.
+func (o *Options) cache(cluster Cluster) *wider.Cache {
+	if o.NoCache || o.CacheTTL == 0 || o.ConfigFlags == nil || o.ConfigFlags.CacheDir == nil || *o.ConfigFlags.CacheDir == "" {
+		return nil
 	}
+	return wider.NewCache(filepath.Join(*o.ConfigFlags.CacheDir, "wider"), cluster.Name, o.CacheTTL)
+}
 
-	return o.printDefault(podNodes)
+// registry returns the relations available to this run.
+func (o *Options) registry() *wider.Registry {
+	if o.Registry == nil {
+		return wider.DefaultRegistry
+	}
+	return o.Registry
 }
@@ -0,0 +1,349 @@
+package wider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	nodesResource             = corev1.SchemeGroupVersion.WithResource("nodes")
+	serviceAccountsResource   = corev1.SchemeGroupVersion.WithResource("serviceaccounts")
+	persistentVolumesResource = corev1.SchemeGroupVersion.WithResource("persistentvolumes")
+)
+
+// nodeRelation joins the node a pod is scheduled on. Only the node metadata is
+// fetched when the request is metadata only, full node lists are cached when
+// the request has a cache.
+type nodeRelation struct{}
+
+func (nodeRelation) Name() string {
+	return "node"
+}
+
+func (nodeRelation) Roots() []string {
+	return []string{"node"}
+}
+
+func (nodeRelation) NewLoader(req LoadRequest) Loader {
+	nodes := newLookup(req, func(ctx context.Context, nodeMap map[string]*corev1.Node) error {
+		if req.MetadataOnly {
+			err := listMetadata(ctx, req, nodesResource, "", func(meta metav1.ObjectMeta) {
+				nodeMap[objectKey("", meta.Name)] = &corev1.Node{ObjectMeta: meta}
+			})
+			if err != nil {
+				return fmt.Errorf("failed to list nodes: %w", err)
+			}
+			return nil
+		}
+		return listNodes(ctx, req, nodeMap)
+	}, func(ctx context.Context, ref objectRef) (*corev1.Node, error) {
+		if req.MetadataOnly {
+			meta, err := getMetadata(ctx, req, nodesResource, ref)
+			return &corev1.Node{ObjectMeta: meta}, err
+		}
+		return getNode(ctx, req, ref)
+	})
+
+	return LoaderFunc(func(ctx context.Context, pods []corev1.Pod) (JoinFunc, error) {
+		var refs []objectRef
+		for i := range pods {
+			if pods[i].Spec.NodeName != "" {
+				refs = append(refs, objectRef{Name: pods[i].Spec.NodeName})
+			}
+		}
+		if err := nodes.resolve(ctx, refs); err != nil {
+			return nil, err
+		}
+
+		return func(pn *PodWithWider) {
+			if pn.Pod.Spec.NodeName == "" {
+				return
+			}
+			pn.Node = nodes.objects[objectKey("", pn.Pod.Spec.NodeName)]
+		}, nil
+	})
+}
+
+func (nodeRelation) Value(pn PodWithWider) (interface{}, bool) {
+	return pn.Node, pn.Node != nil
+}
+
+// listNodes lists every node of the cluster into nodeMap, through the cache
+// of the request when it has one.
+func listNodes(ctx context.Context, req LoadRequest, nodeMap map[string]*corev1.Node) error {
+	return cachedList(ctx, req, nodesResource, nodeMap, func(ctx context.Context, nodeMap map[string]*corev1.Node) error {
+		return req.listPages(ctx, func(opts metav1.ListOptions) (string, error) {
+			nodes, err := req.Clients.Kubernetes.CoreV1().Nodes().List(ctx, opts)
+			if err != nil {
+				return "", fmt.Errorf("failed to list nodes: %w", err)
+			}
+			for i := range nodes.Items {
+				nodeMap[objectKey("", nodes.Items[i].Name)] = &nodes.Items[i]
+			}
+			return nodes.Continue, nil
+		})
+	}, func(ctx context.Context, ref objectRef) (*corev1.Node, error) {
+		return getNode(ctx, req, ref)
+	})
+}
+
+func getNode(ctx context.Context, req LoadRequest, ref objectRef) (*corev1.Node, error) {
+	return req.Clients.Kubernetes.CoreV1().Nodes().Get(ctx, ref.Name, metav1.GetOptions{})
+}
+
+// serviceAccountRelation joins the ServiceAccount a pod runs as.
+type serviceAccountRelation struct{}
+
+func (serviceAccountRelation) Name() string {
+	return "serviceAccount"
+}
+
+func (serviceAccountRelation) Roots() []string {
+	return []string{"serviceAccount", "sa"}
+}
+
+func (serviceAccountRelation) NewLoader(req LoadRequest) Loader {
+	client := req.Clients.Kubernetes
+	sas := newLookup(req, func(ctx context.Context, saMap map[string]*corev1.ServiceAccount) error {
+		if req.MetadataOnly {
+			err := listMetadata(ctx, req, serviceAccountsResource, req.Namespace, func(meta metav1.ObjectMeta) {
+				saMap[objectKey(meta.Namespace, meta.Name)] = &corev1.ServiceAccount{ObjectMeta: meta}
+			})
+			if err != nil {
+				return fmt.Errorf("failed to list ServiceAccounts: %w", err)
+			}
+			return nil
+		}
+		return req.listPages(ctx, func(opts metav1.ListOptions) (string, error) {
+			allSAs, err := client.CoreV1().ServiceAccounts(req.Namespace).List(ctx, opts)
+			if err != nil {
+				return "", fmt.Errorf("failed to list ServiceAccounts: %w", err)
+			}
+			for i := range allSAs.Items {
+				saMap[objectKey(allSAs.Items[i].Namespace, allSAs.Items[i].Name)] = &allSAs.Items[i]
+			}
+			return allSAs.Continue, nil
+		})
+	}, func(ctx context.Context, ref objectRef) (*corev1.ServiceAccount, error) {
+		if req.MetadataOnly {
+			meta, err := getMetadata(ctx, req, serviceAccountsResource, ref)
+			return &corev1.ServiceAccount{ObjectMeta: meta}, err
+		}
+		return client.CoreV1().ServiceAccounts(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	})
+
+	return LoaderFunc(func(ctx context.Context, pods []corev1.Pod) (JoinFunc, error) {
+		var refs []objectRef
+		for i := range pods {
+			pod := &pods[i]
+			if pod.Spec.ServiceAccountName != "" {
+				refs = append(refs, objectRef{pod.Namespace, pod.Spec.ServiceAccountName})
+			}
+		}
+		if err := sas.resolve(ctx, refs); err != nil {
+			return nil, err
+		}
+
+		return func(pn *PodWithWider) {
+			pod := pn.Pod
+			if pod.Spec.ServiceAccountName == "" {
+				return
+			}
+			pn.ServiceAccount = sas.objects[objectKey(pod.Namespace, pod.Spec.ServiceAccountName)]
+		}, nil
+	})
+}
+
+func (serviceAccountRelation) Value(pn PodWithWider) (interface{}, bool) {
+	return pn.ServiceAccount, pn.ServiceAccount != nil
+}
+
+// pvcRelation joins the PersistentVolumeClaims a pod mounts.
+type pvcRelation struct{}
+
+func (pvcRelation) Name() string {
+	return "pvcs"
+}
+
+func (pvcRelation) Roots() []string {
+	return []string{"pvcs", "pvc"}
+}
+
+func (pvcRelation) NewLoader(req LoadRequest) Loader {
+	pvcs := req.claimLookup()
+
+	return LoaderFunc(func(ctx context.Context, pods []corev1.Pod) (JoinFunc, error) {
+		var refs []objectRef
+		for i := range pods {
+			pod := &pods[i]
+			for _, vol := range pod.Spec.Volumes {
+				if vol.PersistentVolumeClaim != nil {
+					refs = append(refs, objectRef{pod.Namespace, vol.PersistentVolumeClaim.ClaimName})
+				}
+			}
+		}
+		if err := pvcs.resolve(ctx, refs); err != nil {
+			return nil, err
+		}
+
+		return func(pn *PodWithWider) {
+			pod := pn.Pod
+			for _, vol := range pod.Spec.Volumes {
+				if vol.PersistentVolumeClaim == nil {
+					continue
+				}
+				if pvc := pvcs.objects[objectKey(pod.Namespace, vol.PersistentVolumeClaim.ClaimName)]; pvc != nil {
+					pn.PVCs = append(pn.PVCs, pvc)
+				}
+			}
+		}, nil
+	})
+}
+
+func (pvcRelation) Value(pn PodWithWider) (interface{}, bool) {
+	return pn.PVCs, len(pn.PVCs) > 0
+}
+
+// sharedClaims holds the PVC lookup of a run. The PVC and PV relations both
+// resolve the claims pods mount, sharing the lookup fetches each claim once.
+type sharedClaims struct {
+	once   sync.Once
+	lookup *lookup[*corev1.PersistentVolumeClaim]
+}
+
+// claimLookup returns the PVC lookup of the run, a new one when the request
+// was not created by the enricher.
+func (req LoadRequest) claimLookup() *lookup[*corev1.PersistentVolumeClaim] {
+	if req.claims == nil {
+		return newClaimLookup(req)
+	}
+	req.claims.once.Do(func() {
+		req.claims.lookup = newClaimLookup(req)
+	})
+	return req.claims.lookup
+}
+
+func newClaimLookup(req LoadRequest) *lookup[*corev1.PersistentVolumeClaim] {
+	client := req.Clients.Kubernetes
+	return newLookup(req, func(ctx context.Context, pvcMap map[string]*corev1.PersistentVolumeClaim) error {
+		return req.listPages(ctx, func(opts metav1.ListOptions) (string, error) {
+			allPVCs, err := client.CoreV1().PersistentVolumeClaims(req.Namespace).List(ctx, opts)
+			if err != nil {
+				return "", fmt.Errorf("failed to list PVCs: %w", err)
+			}
+			for i := range allPVCs.Items {
+				pvcMap[objectKey(allPVCs.Items[i].Namespace, allPVCs.Items[i].Name)] = &allPVCs.Items[i]
+			}
+			return allPVCs.Continue, nil
+		})
+	}, func(ctx context.Context, ref objectRef) (*corev1.PersistentVolumeClaim, error) {
+		return client.CoreV1().PersistentVolumeClaims(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	})
+}
+
+// pvRelation joins the PersistentVolumes bound to the claims a pod mounts.
+// Volumes are looked up by claim: fetched through the volumeName of the claim,
+// resolved with the lookup shared with the PVC relation, or listed and matched
+// on their claimRef. Full volume lists are cached when the request has a cache.
+type pvRelation struct{}
+
+func (pvRelation) Name() string {
+	return "pvs"
+}
+
+func (pvRelation) Roots() []string {
+	return []string{"pvs", "pv"}
+}
+
+func (pvRelation) NewLoader(req LoadRequest) Loader {
+	client := req.Clients.Kubernetes
+	claims := req.claimLookup()
+	// bound holds the claims of the volumes fetched for the current page. It
+	// is a snapshot so fetching a volume never waits for the claim lookup.
+	var bound map[string]*corev1.PersistentVolumeClaim
+	pvs := newLookup(req, func(ctx context.Context, pvMap map[string]*corev1.PersistentVolume) error {
+		byName := make(map[string]*corev1.PersistentVolume)
+		err := cachedList(ctx, req, persistentVolumesResource, byName, func(ctx context.Context, byName map[string]*corev1.PersistentVolume) error {
+			return req.listPages(ctx, func(opts metav1.ListOptions) (string, error) {
+				allPVs, err := client.CoreV1().PersistentVolumes().List(ctx, opts)
+				if err != nil {
+					return "", fmt.Errorf("failed to list PVs: %w", err)
+				}
+				for i := range allPVs.Items {
+					byName[objectKey("", allPVs.Items[i].Name)] = &allPVs.Items[i]
+				}
+				return allPVs.Continue, nil
+			})
+		}, func(ctx context.Context, ref objectRef) (*corev1.PersistentVolume, error) {
+			return client.CoreV1().PersistentVolumes().Get(ctx, ref.Name, metav1.GetOptions{})
+		})
+		if err != nil {
+			return err
+		}
+		for _, pv := range byName {
+			claim := pv.Spec.ClaimRef
+			if claim == nil || (req.Namespace != "" && claim.Namespace != req.Namespace) {
+				continue
+			}
+			pvMap[objectKey(claim.Namespace, claim.Name)] = pv
+		}
+		return nil
+	}, func(ctx context.Context, ref objectRef) (*corev1.PersistentVolume, error) {
+		pvc := bound[objectKey(ref.Namespace, ref.Name)]
+		if pvc == nil || pvc.Spec.VolumeName == "" {
+			// Missing and unbound claims have no volume to join, nor do
+			// claims left out of a listed relation
+			return nil, nil
+		}
+		return client.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+	})
+
+	return LoaderFunc(func(ctx context.Context, pods []corev1.Pod) (JoinFunc, error) {
+		var refs []objectRef
+		for i := range pods {
+			for _, claim := range podClaims(&pods[i]) {
+				refs = append(refs, objectRef{pods[i].Namespace, claim})
+			}
+		}
+		// Volumes fetched one by one are found through their claim, claims
+		// already loaded for the PVC relation are not fetched again. Claims
+		// are resolved before any volume call takes a slot.
+		bound = nil
+		if missing := pvs.missing(refs); !pvs.listsFor(missing) {
+			if err := claims.resolve(ctx, missing); err != nil {
+				return nil, err
+			}
+			bound = claims.snapshot(missing)
+		}
+		if err := pvs.resolve(ctx, refs); err != nil {
+			return nil, err
+		}
+
+		return func(pn *PodWithWider) {
+			for _, claim := range podClaims(pn.Pod) {
+				if pv := pvs.objects[objectKey(pn.Pod.Namespace, claim)]; pv != nil {
+					pn.PVs = append(pn.PVs, pv)
+				}
+			}
+		}, nil
+	})
+}
+
+func (pvRelation) Value(pn PodWithWider) (interface{}, bool) {
+	return pn.PVs, len(pn.PVs) > 0
+}
+
+// podClaims returns the names of the PersistentVolumeClaims a pod mounts.
+func podClaims(pod *corev1.Pod) []string {
+	var claims []string
+	for _, vol := range pod.Spec.Volumes {
+		if vol.PersistentVolumeClaim != nil {
+			claims = append(claims, vol.PersistentVolumeClaim.ClaimName)
+		}
+	}
+	return claims
+}
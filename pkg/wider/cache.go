@@ -0,0 +1,147 @@
+package wider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// DefaultCacheTTL is how long cached lists are reused before being listed again.
+const DefaultCacheTTL = 10 * time.Minute
+
+// Cache keeps lists of slow-changing cluster-scoped objects on disk between
+// runs. Cached objects are validated against a metadata list on every run and
+// only those whose resourceVersion changed are fetched again.
+type Cache struct {
+	dir string
+	ttl time.Duration
+}
+
+var unsafeCacheChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// NewCache returns a cache for a kubeconfig context stored under dir, e.g.
+// ~/.kube/cache/wider. Lists older than ttl are discarded.
+func NewCache(dir, contextName string, ttl time.Duration) *Cache {
+	return &Cache{
+		dir: filepath.Join(dir, unsafeCacheChars.ReplaceAllString(contextName, "_")),
+		ttl: ttl,
+	}
+}
+
+// cacheFile is the on-disk format of a cached list.
+type cacheFile[T any] struct {
+	// ListedAt is when the objects were last listed in full.
+	ListedAt time.Time    `json:"listedAt"`
+	Objects  map[string]T `json:"objects"`
+}
+
+func (c *Cache) path(gvr schema.GroupVersionResource) string {
+	return filepath.Join(c.dir, gvr.GroupResource().String()+".json")
+}
+
+// readCache returns the cached objects of a resource, false when there are none or they expired.
+func readCache[T any](c *Cache, gvr schema.GroupVersionResource) (cacheFile[T], bool) {
+	var file cacheFile[T]
+	data, err := os.ReadFile(c.path(gvr))
+	if err != nil {
+		return file, false
+	}
+	if err := json.Unmarshal(data, &file); err != nil || file.Objects == nil {
+		return file, false
+	}
+	return file, time.Since(file.ListedAt) < c.ttl
+}
+
+// writeCache stores the objects of a resource, replacing the file atomically.
+func writeCache[T any](c *Cache, gvr schema.GroupVersionResource, file cacheFile[T]) error {
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0o750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(gvr))
+}
+
+// cachedList fills objects with a cluster-scoped resource, reusing the cached
+// objects whose resourceVersion still matches the metadata list. Changed
+// objects are fetched with get while there are at most GetThreshold of them,
+// otherwise everything is listed again. Without a cache or a metadata client
+// the resource is simply listed.
+func cachedList[T interface {
+	comparable
+	metav1.Object
+}](ctx context.Context, req LoadRequest, gvr schema.GroupVersionResource, objects map[string]T,
+	list func(context.Context, map[string]T) error, get func(context.Context, objectRef) (T, error)) error {
+	c := req.Cache
+	if c == nil || req.Clients.Metadata == nil {
+		return list(ctx, objects)
+	}
+
+	file, ok := readCache[T](c, gvr)
+	if ok {
+		current := make(map[string]T)
+		var changed []objectRef
+		err := listMetadata(ctx, req, gvr, "", func(meta metav1.ObjectMeta) {
+			key := objectKey("", meta.Name)
+			if cached, ok := file.Objects[key]; ok && cached.GetResourceVersion() == meta.ResourceVersion {
+				current[key] = cached
+				return
+			}
+			changed = append(changed, objectRef{Name: meta.Name})
+		})
+		if err != nil {
+			return fmt.Errorf("failed to validate cached %s: %w", gvr.Resource, err)
+		}
+
+		threshold := getThreshold(req.GetThreshold)
+		if threshold >= 0 && len(changed) <= threshold {
+			if err := getMissing(ctx, req.calls, current, changed, get); err != nil {
+				return err
+			}
+			var zero T
+			for key, obj := range current {
+				if obj == zero {
+					delete(current, key)
+				}
+			}
+			for key, obj := range current {
+				objects[key] = obj
+			}
+			file.Objects = current
+			// The cache is only an optimisation, failing to update it is not an error
+			_ = writeCache(c, gvr, file)
+			return nil
+		}
+	}
+
+	listed := make(map[string]T)
+	if err := list(ctx, listed); err != nil {
+		return err
+	}
+	for key, obj := range listed {
+		objects[key] = obj
+	}
+	_ = writeCache(c, gvr, cacheFile[T]{ListedAt: time.Now(), Objects: listed})
+	return nil
+}
@@ -0,0 +1,107 @@
+package wider
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Container types reported by ContainerWithStatus.Type.
+const (
+	ContainerTypeInit      = "init"
+	ContainerTypeRegular   = "container"
+	ContainerTypeEphemeral = "ephemeral"
+)
+
+// ContainerWithStatus is a container spec joined with its status, the value of the .container root.
+type ContainerWithStatus struct {
+	corev1.Container `json:",inline"`
+	// Type is one of init, container or ephemeral.
+	Type string `json:"type"`
+	// Status is the matching entry of the pod's container statuses, nil before the container was created.
+	Status *corev1.ContainerStatus `json:"status,omitempty"`
+}
+
+// PodContainers returns the init, regular and ephemeral containers of a pod with their statuses.
+func PodContainers(pod *corev1.Pod) []ContainerWithStatus {
+	var containers []ContainerWithStatus
+	for _, c := range pod.Spec.InitContainers {
+		containers = append(containers, ContainerWithStatus{
+			Container: c,
+			Type:      ContainerTypeInit,
+			Status:    containerStatus(pod.Status.InitContainerStatuses, c.Name),
+		})
+	}
+	for _, c := range pod.Spec.Containers {
+		containers = append(containers, ContainerWithStatus{
+			Container: c,
+			Type:      ContainerTypeRegular,
+			Status:    containerStatus(pod.Status.ContainerStatuses, c.Name),
+		})
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		containers = append(containers, ContainerWithStatus{
+			Container: corev1.Container(c.EphemeralContainerCommon),
+			Type:      ContainerTypeEphemeral,
+			Status:    containerStatus(pod.Status.EphemeralContainerStatuses, c.Name),
+		})
+	}
+	return containers
+}
+
+func containerStatus(statuses []corev1.ContainerStatus, name string) *corev1.ContainerStatus {
+	for i := range statuses {
+		if statuses[i].Name == name {
+			return &statuses[i]
+		}
+	}
+	return nil
+}
+
+// ExpandContainers returns one row per container of every pod, with Container set.
+func ExpandContainers(podNodes []PodWithWider) []PodWithWider {
+	var rows []PodWithWider
+	for _, pn := range podNodes {
+		for _, c := range PodContainers(pn.Pod) {
+			row := pn
+			row.Container = &c
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// ContainerState summarises the current state of a container like kubectl
+// does, using the waiting or termination reason when there is one.
+func ContainerState(c ContainerWithStatus) string {
+	if c.Status == nil {
+		return "<none>"
+	}
+	state := c.Status.State
+	switch {
+	case state.Running != nil:
+		return "Running"
+	case state.Waiting != nil && state.Waiting.Reason != "":
+		return state.Waiting.Reason
+	case state.Waiting != nil:
+		return "Waiting"
+	case state.Terminated != nil && state.Terminated.Reason != "":
+		return state.Terminated.Reason
+	case state.Terminated != nil:
+		return "Terminated"
+	}
+	return "<none>"
+}
+
+// LastTermination returns the reason and exit code of the previous termination of a container.
+func LastTermination(c ContainerWithStatus) string {
+	if c.Status == nil || c.Status.LastTerminationState.Terminated == nil {
+		return "<none>"
+	}
+	terminated := c.Status.LastTerminationState.Terminated
+	reason := terminated.Reason
+	if reason == "" {
+		reason = "Terminated"
+	}
+	return fmt.Sprintf("%s (%d)", reason, terminated.ExitCode)
+}
@@ -0,0 +1,387 @@
+package wider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+// RelationsConfig is the file format declaring custom resource relations.
+type RelationsConfig struct {
+	Relations []CustomRelation `json:"relations"`
+}
+
+// CustomRelation declares a relation to a custom resource evaluated with the dynamic client.
+//
+// A resource is joined to a pod when the value at Match.Field equals one of the
+// pod keys selected by Match.Pod. Namespaced resources only match pods in the
+// same namespace. When several resources match, the first by name is used.
+type CustomRelation struct {
+	// Name identifies the relation and is the default root.
+	Name string `json:"name"`
+	// Roots overrides the path roots resolving to the relation.
+	Roots []string `json:"roots,omitempty"`
+
+	Group         string `json:"group,omitempty"`
+	Version       string `json:"version"`
+	Resource      string `json:"resource"`
+	ClusterScoped bool   `json:"clusterScoped,omitempty"`
+
+	Match CustomMatch `json:"match"`
+}
+
+// CustomMatch describes how a custom resource is joined to a pod.
+type CustomMatch struct {
+	// Field is a dot separated path into the custom resource, e.g. spec.targetRef.name.
+	// String and string list values are supported.
+	Field string `json:"field"`
+	// Pod selects the pod keys compared with Field, one of name, nodeName,
+	// serviceAccount, controller, workload, secrets, configMaps, pvcs or label:<key>.
+	Pod string `json:"pod"`
+}
+
+var podKeys = map[string]bool{
+	"name":           true,
+	"nodeName":       true,
+	"serviceAccount": true,
+	"controller":     true,
+	"workload":       true,
+	"secrets":        true,
+	"configMaps":     true,
+	"pvcs":           true,
+}
+
+// LoadRelationsConfig reads custom relations from a YAML or JSON file.
+func LoadRelationsConfig(path string) ([]Relation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read relations config: %w", err)
+	}
+
+	var config RelationsConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse relations config %s: %w", path, err)
+	}
+
+	var relations []Relation
+	for _, def := range config.Relations {
+		rel, err := NewCustomRelation(def)
+		if err != nil {
+			return nil, fmt.Errorf("invalid relation in %s: %w", path, err)
+		}
+		relations = append(relations, rel)
+	}
+	return relations, nil
+}
+
+// NewCustomRelation validates def and returns a relation backed by the dynamic client.
+func NewCustomRelation(def CustomRelation) (Relation, error) {
+	if def.Name == "" {
+		return nil, fmt.Errorf("relation name is required")
+	}
+	if def.Version == "" || def.Resource == "" {
+		return nil, fmt.Errorf("relation %s: version and resource are required", def.Name)
+	}
+	if def.Match.Field == "" {
+		return nil, fmt.Errorf("relation %s: match.field is required", def.Name)
+	}
+	if !podKeys[def.Match.Pod] && !strings.HasPrefix(def.Match.Pod, "label:") {
+		return nil, fmt.Errorf("relation %s: unsupported match.pod %q", def.Name, def.Match.Pod)
+	}
+	if isPodWithWiderField(def.Name) {
+		return nil, fmt.Errorf("relation %s: name is reserved", def.Name)
+	}
+	if len(def.Roots) == 0 {
+		def.Roots = []string{def.Name}
+	}
+	for _, root := range def.Roots {
+		if slices.Contains(builtinRoots, root) {
+			return nil, fmt.Errorf("relation %s: root %s is reserved", def.Name, root)
+		}
+	}
+	return customRelation{def: def}, nil
+}
+
+// customRelation joins custom resources listed with the dynamic client.
+type customRelation struct {
+	def CustomRelation
+}
+
+func (r customRelation) Name() string {
+	return r.def.Name
+}
+
+func (r customRelation) Roots() []string {
+	return r.def.Roots
+}
+
+func (r customRelation) NewLoader(req LoadRequest) Loader {
+	gvr := schema.GroupVersionResource{Group: r.def.Group, Version: r.def.Version, Resource: r.def.Resource}
+	resource := func(namespace string) dynamic.ResourceInterface {
+		if r.def.ClusterScoped {
+			return req.Clients.Dynamic.Resource(gvr)
+		}
+		return req.Clients.Dynamic.Resource(gvr).Namespace(namespace)
+	}
+
+	objects := newLookup(req, func(ctx context.Context, index map[string]*unstructured.Unstructured) error {
+		return r.list(ctx, req, resource(req.Namespace), index)
+	}, func(ctx context.Context, ref objectRef) (*unstructured.Unstructured, error) {
+		return resource(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	})
+	// Resources can only be fetched by name when that is what the pod is matched on
+	if r.def.Match.Field != "metadata.name" {
+		objects.req.GetThreshold = -1
+	}
+
+	return LoaderFunc(func(ctx context.Context, pods []corev1.Pod) (JoinFunc, error) {
+		if req.Clients.Dynamic == nil {
+			return nil, fmt.Errorf("relation %s requires a dynamic client", r.def.Name)
+		}
+
+		var refs []objectRef
+		if objects.req.GetThreshold >= 0 {
+			for i := range pods {
+				for _, value := range podKeyValues(&pods[i], r.def.Match.Pod) {
+					refs = append(refs, r.ref(pods[i].Namespace, value))
+				}
+			}
+		}
+		if err := objects.resolve(ctx, refs); err != nil {
+			return nil, err
+		}
+
+		return func(pn *PodWithWider) {
+			for _, value := range podKeyValues(pn.Pod, r.def.Match.Pod) {
+				ref := r.ref(pn.Pod.Namespace, value)
+				if obj := objects.objects[objectKey(ref.Namespace, ref.Name)]; obj != nil {
+					pn.SetRelated(r.def.Name, obj)
+					return
+				}
+			}
+		}, nil
+	})
+}
+
+// list indexes the custom resources by the value of the match field. When
+// several resources share a value the first by name wins.
+func (r customRelation) list(ctx context.Context, req LoadRequest, resource dynamic.ResourceInterface, index map[string]*unstructured.Unstructured) error {
+	var items []unstructured.Unstructured
+	err := req.listPages(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := resource.List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, list.Items...)
+		return list.GetContinue(), nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", schema.GroupResource{Group: r.def.Group, Resource: r.def.Resource}, err)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].GetName() < items[j].GetName()
+	})
+	for i := range items {
+		obj := &items[i]
+		for _, value := range fieldValues(obj.Object, r.def.Match.Field) {
+			ref := r.ref(obj.GetNamespace(), value)
+			key := objectKey(ref.Namespace, ref.Name)
+			if index[key] == nil {
+				index[key] = obj
+			}
+		}
+	}
+	return nil
+}
+
+func (r customRelation) Value(pn PodWithWider) (interface{}, bool) {
+	value, ok := pn.Related[r.def.Name]
+	return value, ok
+}
+
+// ref returns the key a match value is indexed under, namespaced resources
+// only match pods in their own namespace.
+func (r customRelation) ref(namespace, value string) objectRef {
+	if r.def.ClusterScoped {
+		return objectRef{Name: value}
+	}
+	return objectRef{Namespace: namespace, Name: value}
+}
+
+// fieldValues returns the string or string list found at a dot separated path.
+func fieldValues(obj map[string]interface{}, field string) []string {
+	value, found, err := unstructured.NestedFieldNoCopy(obj, splitPath(field)...)
+	if !found || err != nil {
+		return nil
+	}
+
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// podKeyValues returns the values a pod exposes for a match key.
+func podKeyValues(pod *corev1.Pod, key string) []string {
+	if label, ok := strings.CutPrefix(key, "label:"); ok {
+		if value, ok := pod.Labels[label]; ok {
+			return []string{value}
+		}
+		return nil
+	}
+
+	switch key {
+	case "name":
+		return []string{pod.Name}
+	case "nodeName":
+		return nonEmpty(pod.Spec.NodeName)
+	case "serviceAccount":
+		return nonEmpty(pod.Spec.ServiceAccountName)
+	case "controller":
+		if ref := metav1.GetControllerOf(pod); ref != nil {
+			return []string{ref.Name}
+		}
+	case "workload":
+		return nonEmpty(workloadName(pod))
+	case "secrets":
+		return podSecrets(pod)
+	case "configMaps":
+		return podConfigMaps(pod)
+	case "pvcs":
+		var names []string
+		for _, vol := range pod.Spec.Volumes {
+			if vol.PersistentVolumeClaim != nil {
+				names = append(names, vol.PersistentVolumeClaim.ClaimName)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// templateHashLabels maps the labels Deployments and Argo Rollouts put on the
+// pods of their ReplicaSets, whose names end with the hash, to the kind of the
+// workload owning the ReplicaSet.
+var templateHashLabels = []struct{ label, kind string }{
+	{"pod-template-hash", "Deployment"},
+	{"rollouts-pod-template-hash", "Rollout"},
+}
+
+// workloadName returns the name of the workload managing the pod. Pods owned by
+// a ReplicaSet report the Deployment or Rollout name by removing the pod
+// template hash from the ReplicaSet name.
+func workloadName(pod *corev1.Pod) string {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return ""
+	}
+	_, name := controllerWorkload(pod, ref)
+	return name
+}
+
+// controllerWorkload returns the kind and name of the workload behind the
+// controller of the pod, folding a ReplicaSet into its Deployment or Rollout.
+func controllerWorkload(pod *corev1.Pod, ref *metav1.OwnerReference) (string, string) {
+	if ref.Kind == "ReplicaSet" {
+		for _, h := range templateHashLabels {
+			if hash := pod.Labels[h.label]; hash != "" {
+				if name, ok := strings.CutSuffix(ref.Name, "-"+hash); ok {
+					return h.kind, name
+				}
+			}
+		}
+	}
+	return ref.Kind, ref.Name
+}
+
+// podSecrets returns the secrets referenced by the pod's volumes and environment.
+func podSecrets(pod *corev1.Pod) []string {
+	var names []string
+	for _, vol := range pod.Spec.Volumes {
+		if vol.Secret != nil {
+			names = append(names, vol.Secret.SecretName)
+		}
+		if vol.Projected != nil {
+			for _, source := range vol.Projected.Sources {
+				if source.Secret != nil {
+					names = append(names, source.Secret.Name)
+				}
+			}
+		}
+	}
+	for _, c := range allContainers(pod) {
+		for _, env := range c.EnvFrom {
+			if env.SecretRef != nil {
+				names = append(names, env.SecretRef.Name)
+			}
+		}
+		for _, env := range c.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+				names = append(names, env.ValueFrom.SecretKeyRef.Name)
+			}
+		}
+	}
+	return names
+}
+
+// podConfigMaps returns the ConfigMaps referenced by the pod's volumes and environment.
+func podConfigMaps(pod *corev1.Pod) []string {
+	var names []string
+	for _, vol := range pod.Spec.Volumes {
+		if vol.ConfigMap != nil {
+			names = append(names, vol.ConfigMap.Name)
+		}
+		if vol.Projected != nil {
+			for _, source := range vol.Projected.Sources {
+				if source.ConfigMap != nil {
+					names = append(names, source.ConfigMap.Name)
+				}
+			}
+		}
+	}
+	for _, c := range allContainers(pod) {
+		for _, env := range c.EnvFrom {
+			if env.ConfigMapRef != nil {
+				names = append(names, env.ConfigMapRef.Name)
+			}
+		}
+		for _, env := range c.Env {
+			if env.ValueFrom != nil && env.ValueFrom.ConfigMapKeyRef != nil {
+				names = append(names, env.ValueFrom.ConfigMapKeyRef.Name)
+			}
+		}
+	}
+	return names
+}
+
+// allContainers returns the pod's init and regular containers.
+func allContainers(pod *corev1.Pod) []corev1.Container {
+	return append(append([]corev1.Container(nil), pod.Spec.InitContainers...), pod.Spec.Containers...)
+}
+
+func nonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
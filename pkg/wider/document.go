@@ -0,0 +1,149 @@
+package wider
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+)
+
+// IsDocumentOutput reports whether format renders a table for pasting into
+// documents: markdown or html, with the default columns or custom columns
+// such as markdown=NAME:.pod.metadata.name.
+func IsDocumentOutput(format string) bool {
+	if format == "markdown" || format == "html" {
+		return true
+	}
+	kind, _, ok := ColumnsOutput(format)
+	return ok && (kind == "markdown" || kind == "html")
+}
+
+// documentPrinter renders markdown or html tables. Pods are buffered so rows
+// can be grouped under a heading per value of PrintOptions.GroupBy.
+type documentPrinter struct {
+	out      io.Writer
+	html     bool
+	headers  []string
+	row      func(pn PodWithWider) []string
+	groupBy  *Path
+	registry *Registry
+	podNodes []PodWithWider
+}
+
+func newDocumentPrinter(out io.Writer, opts PrintOptions) (Printer, error) {
+	p := &documentPrinter{
+		out:      out,
+		html:     strings.HasPrefix(opts.OutputFormat, "html"),
+		registry: opts.registry(),
+	}
+
+	if _, _, ok := ColumnsOutput(opts.OutputFormat); ok {
+		headers, paths, err := parseCustomColumns(opts.OutputFormat)
+		if err != nil {
+			return nil, err
+		}
+		p.headers = headers
+		p.row = customColumnsRow(p.registry, paths)
+	} else {
+		p.headers = defaultHeaders(opts)
+		p.row = func(pn PodWithWider) []string {
+			return defaultRow(opts, pn)
+		}
+	}
+
+	if opts.GroupBy != "" {
+		path, err := ParsePath(opts.GroupBy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group-by path %q: %w", opts.GroupBy, err)
+		}
+		p.groupBy = &path
+	}
+	return p, nil
+}
+
+func (p *documentPrinter) PrintPage(podNodes []PodWithWider) error {
+	p.podNodes = append(p.podNodes, podNodes...)
+	return nil
+}
+
+func (p *documentPrinter) Flush() error {
+	if p.groupBy == nil {
+		return p.writeTable(p.podNodes)
+	}
+
+	groups := make(map[string][]PodWithWider)
+	for _, pn := range p.podNodes {
+		value, err := p.registry.Evaluate(pn, *p.groupBy)
+		if err != nil || value == "" {
+			value = "<none>"
+		}
+		groups[value] = append(groups[value], pn)
+	}
+	values := make([]string, 0, len(groups))
+	for value := range groups {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	for i, value := range values {
+		if i > 0 {
+			fmt.Fprintln(p.out)
+		}
+		if p.html {
+			fmt.Fprintf(p.out, "<h2>%s</h2>\n", html.EscapeString(value))
+		} else {
+			fmt.Fprintf(p.out, "## %s\n\n", escapeMarkdown(value))
+		}
+		if err := p.writeTable(groups[value]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *documentPrinter) writeTable(podNodes []PodWithWider) error {
+	var b strings.Builder
+	if p.html {
+		b.WriteString("<table>\n<thead>\n")
+		writeHTMLRow(&b, "th", p.headers)
+		b.WriteString("</thead>\n<tbody>\n")
+		for _, pn := range podNodes {
+			writeHTMLRow(&b, "td", p.row(pn))
+		}
+		b.WriteString("</tbody>\n</table>\n")
+	} else {
+		writeMarkdownRow(&b, p.headers)
+		separators := make([]string, len(p.headers))
+		for i := range separators {
+			separators[i] = "---"
+		}
+		writeMarkdownRow(&b, separators)
+		for _, pn := range podNodes {
+			writeMarkdownRow(&b, p.row(pn))
+		}
+	}
+	_, err := io.WriteString(p.out, b.String())
+	return err
+}
+
+func writeMarkdownRow(b *strings.Builder, values []string) {
+	b.WriteString("|")
+	for _, value := range values {
+		b.WriteString(" " + escapeMarkdown(value) + " |")
+	}
+	b.WriteString("\n")
+}
+
+// escapeMarkdown keeps a value within its table cell.
+func escapeMarkdown(value string) string {
+	return strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>").Replace(value)
+}
+
+func writeHTMLRow(b *strings.Builder, cell string, values []string) {
+	b.WriteString("<tr>")
+	for _, value := range values {
+		fmt.Fprintf(b, "<%s>%s</%s>", cell, html.EscapeString(value), cell)
+	}
+	b.WriteString("</tr>\n")
+}
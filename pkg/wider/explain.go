@@ -0,0 +1,259 @@
+package wider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	resourcehelper "k8s.io/component-helpers/resource"
+	corev1helpers "k8s.io/component-helpers/scheduling/corev1"
+	"k8s.io/component-helpers/scheduling/corev1/nodeaffinity"
+)
+
+// Explanation is why a pod waiting to be scheduled does or does not fit each node.
+type Explanation struct {
+	Cluster string      `json:"cluster,omitempty"`
+	Pod     *corev1.Pod `json:"pod"`
+	Nodes   []NodeFit   `json:"nodes"`
+}
+
+// NodeFit is the result of evaluating a pod against a node, it fits when there are no reasons.
+type NodeFit struct {
+	Node    string   `json:"node"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// Fits reports whether the pod can be scheduled on the node.
+func (f NodeFit) Fits() bool {
+	return len(f.Reasons) == 0
+}
+
+// Explain evaluates every pod that has not been scheduled yet against the
+// nodes of the cluster: node selector, required node affinity, taints and the
+// resources left on the node by the pods already running there. Unless
+// Options.Strict is set, the resources are not checked when listing the pods
+// of every namespace is forbidden, and nothing is explained when listing
+// nodes is, both with a warning.
+func (e *Enricher) Explain(ctx context.Context) ([]Explanation, error) {
+	podNodes, err := e.Enrich(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []*corev1.Pod
+	for _, pn := range podNodes {
+		if pn.Pod.Spec.NodeName == "" && pn.Pod.DeletionTimestamp == nil && pn.Pod.Status.Phase == corev1.PodPending {
+			pending = append(pending, pn.Pod)
+		}
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	req := e.loadRequest()
+	nodeMap := make(map[string]*corev1.Node)
+	if err := listNodes(ctx, req, nodeMap); err != nil {
+		if e.opts.Strict || !apierrors.IsForbidden(err) {
+			return nil, err
+		}
+		e.warn(fmt.Sprintf("nodes are forbidden, scheduling of %d pending pods is not explained: %v", len(pending), err))
+		return nil, nil
+	}
+	nodes := make([]*corev1.Node, 0, len(nodeMap))
+	for _, node := range nodeMap {
+		nodes = append(nodes, node)
+	}
+
+	usage, err := nodeUsage(ctx, req)
+	if err != nil {
+		if e.opts.Strict || !apierrors.IsForbidden(err) {
+			return nil, err
+		}
+		e.warn(fmt.Sprintf("pods of other namespaces are forbidden, free resources are not checked: %v", err))
+		usage = nil
+	}
+
+	var explanations []Explanation
+	for _, pod := range pending {
+		explanations = append(explanations, Explanation{
+			Cluster: e.opts.Cluster,
+			Pod:     pod,
+			Nodes:   ExplainScheduling(pod, nodes, usage),
+		})
+	}
+	return explanations, nil
+}
+
+// NodeUsage is what the pods already bound to a node request.
+type NodeUsage struct {
+	Requests corev1.ResourceList
+	Pods     int64
+}
+
+// nodeUsage sums the requests of the pods running on every node of the cluster.
+func nodeUsage(ctx context.Context, req LoadRequest) (map[string]*NodeUsage, error) {
+	usage := make(map[string]*NodeUsage)
+	err := req.listPages(ctx, func(opts metav1.ListOptions) (string, error) {
+		opts.FieldSelector = "spec.nodeName!=,status.phase!=Succeeded,status.phase!=Failed"
+		pods, err := req.Clients.Kubernetes.CoreV1().Pods("").List(ctx, opts)
+		if err != nil {
+			return "", fmt.Errorf("failed to list scheduled pods: %w", err)
+		}
+		for i := range pods.Items {
+			addNodeUsage(usage, &pods.Items[i])
+		}
+		return pods.Continue, nil
+	})
+	return usage, err
+}
+
+func addNodeUsage(usage map[string]*NodeUsage, pod *corev1.Pod) {
+	if pod.Spec.NodeName == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return
+	}
+	u := usage[pod.Spec.NodeName]
+	if u == nil {
+		u = &NodeUsage{Requests: corev1.ResourceList{}}
+		usage[pod.Spec.NodeName] = u
+	}
+	for name, q := range resourcehelper.PodRequests(pod, resourcehelper.PodResourcesOptions{}) {
+		total := u.Requests[name]
+		total.Add(q)
+		u.Requests[name] = total
+	}
+	u.Pods++
+}
+
+// ExplainScheduling evaluates a pod against every node, given what the pods
+// already bound to each node request. Resources are not checked when usage is
+// nil. Nodes are returned sorted, fitting first.
+func ExplainScheduling(pod *corev1.Pod, nodes []*corev1.Node, usage map[string]*NodeUsage) []NodeFit {
+	requests := resourcehelper.PodRequests(pod, resourcehelper.PodResourcesOptions{})
+	affinity := nodeaffinity.GetRequiredNodeAffinity(&corev1.Pod{Spec: corev1.PodSpec{Affinity: pod.Spec.Affinity}})
+
+	var fits []NodeFit
+	for _, node := range nodes {
+		var reasons []string
+
+		if node.Spec.Unschedulable && !tolerates(pod, corev1.Taint{Key: corev1.TaintNodeUnschedulable, Effect: corev1.TaintEffectNoSchedule}) {
+			reasons = append(reasons, "node is unschedulable")
+		}
+		if len(pod.Spec.NodeSelector) > 0 && !labels.SelectorFromSet(pod.Spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+			reasons = append(reasons, fmt.Sprintf("nodeSelector %s not matched", labels.Set(pod.Spec.NodeSelector)))
+		}
+		if ok, err := affinity.Match(node); err != nil {
+			reasons = append(reasons, fmt.Sprintf("invalid node affinity: %v", err))
+		} else if !ok {
+			reasons = append(reasons, "required node affinity not matched")
+		}
+		taint, untolerated := corev1helpers.FindMatchingUntoleratedTaint(node.Spec.Taints, pod.Spec.Tolerations, func(t *corev1.Taint) bool {
+			return t.Effect == corev1.TaintEffectNoSchedule || t.Effect == corev1.TaintEffectNoExecute
+		})
+		if untolerated {
+			reasons = append(reasons, fmt.Sprintf("untolerated taint %s", taint.ToString()))
+		}
+		if usage != nil {
+			reasons = append(reasons, insufficientResources(requests, node, usage[node.Name])...)
+		}
+
+		fits = append(fits, NodeFit{Node: node.Name, Reasons: reasons})
+	}
+
+	sort.SliceStable(fits, func(i, j int) bool {
+		if fits[i].Fits() != fits[j].Fits() {
+			return fits[i].Fits()
+		}
+		return fits[i].Node < fits[j].Node
+	})
+	return fits
+}
+
+func tolerates(pod *corev1.Pod, taint corev1.Taint) bool {
+	return corev1helpers.TolerationsTolerateTaint(pod.Spec.Tolerations, &taint)
+}
+
+// insufficientResources compares the pod requests with what the node has left.
+func insufficientResources(requests corev1.ResourceList, node *corev1.Node, usage *NodeUsage) []string {
+	if usage == nil {
+		usage = &NodeUsage{}
+	}
+
+	var reasons []string
+	if allocatable, ok := node.Status.Allocatable[corev1.ResourcePods]; ok && usage.Pods+1 > allocatable.Value() {
+		reasons = append(reasons, fmt.Sprintf("too many pods (%d/%d)", usage.Pods, allocatable.Value()))
+	}
+
+	var names []string
+	for name := range requests {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		requested := requests[corev1.ResourceName(name)]
+		if requested.IsZero() {
+			continue
+		}
+		allocatable, ok := node.Status.Allocatable[corev1.ResourceName(name)]
+		if !ok {
+			reasons = append(reasons, fmt.Sprintf("no %s on node", name))
+			continue
+		}
+		free := allocatable.DeepCopy()
+		free.Sub(usage.Requests[corev1.ResourceName(name)])
+		if requested.Cmp(free) > 0 {
+			reasons = append(reasons, fmt.Sprintf("insufficient %s (requested %s, free %s of %s)", name, requested.String(), free.String(), allocatable.String()))
+		}
+	}
+	return reasons
+}
+
+// PrintExplanations writes explanations to w as a table with a row per pod
+// and node, or as json or yaml when opts.OutputFormat selects them.
+func PrintExplanations(w io.Writer, opts PrintOptions, explanations []Explanation) error {
+	switch opts.OutputFormat {
+	case "json":
+		return printJSON(w, explanations)
+	case "yaml":
+		return printYAML(w, explanations)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	var headers []string
+	if opts.ShowCluster {
+		headers = append(headers, "CLUSTER")
+	}
+	if opts.AllNamespaces {
+		headers = append(headers, "NAMESPACE")
+	}
+	headers = append(headers, "POD", "NODE", "FITS", "REASONS")
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+
+	for _, explanation := range explanations {
+		if len(explanation.Nodes) == 0 {
+			explanation.Nodes = []NodeFit{{Reasons: []string{"no nodes"}}}
+		}
+		for _, fit := range explanation.Nodes {
+			var values []string
+			if opts.ShowCluster {
+				values = append(values, explanation.Cluster)
+			}
+			if opts.AllNamespaces {
+				values = append(values, explanation.Pod.Namespace)
+			}
+			values = append(values,
+				explanation.Pod.Name,
+				noneIfEmpty(fit.Node),
+				fmt.Sprintf("%t", fit.Fits()),
+				noneIfEmpty(strings.Join(fit.Reasons, "; ")))
+			fmt.Fprintln(tw, strings.Join(values, "\t"))
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,245 @@
+package wider
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// Subgraph groupings supported by PrintOptions.SubgraphBy.
+const (
+	SubgraphNamespace = "namespace"
+	SubgraphZone      = "zone"
+)
+
+// IsGraphOutput reports whether format renders the relations between pods and
+// the objects joined to them as a graph.
+func IsGraphOutput(format string) bool {
+	return format == "dot" || format == "mermaid"
+}
+
+// ValidateSubgraphBy reports whether by is a supported subgraph grouping.
+func ValidateSubgraphBy(by string) error {
+	switch by {
+	case "", SubgraphNamespace, SubgraphZone:
+		return nil
+	}
+	return fmt.Errorf("unsupported subgraph grouping: %s (supported: %s, %s)", by, SubgraphNamespace, SubgraphZone)
+}
+
+// graphVertex is an object of the graph, Group is the subgraph it is drawn in.
+type graphVertex struct {
+	ID    string
+	Label string
+	Group string
+}
+
+type graphEdge struct {
+	From, To, Label string
+}
+
+// graph is the set of objects joined to pods and their relations, in the
+// order they were first seen.
+type graph struct {
+	vertices []*graphVertex
+	byID     map[string]*graphVertex
+	edges    []graphEdge
+	seen     map[graphEdge]bool
+}
+
+// buildGraph joins pods to their node, ServiceAccount, PVCs and the PVs
+// bound to them, and the values of other relations. Vertices are grouped by
+// namespace or by zone when opts.SubgraphBy selects it.
+func buildGraph(opts PrintOptions, podNodes []PodWithWider) *graph {
+	g := &graph{byID: make(map[string]*graphVertex), seen: make(map[graphEdge]bool)}
+
+	for _, pn := range podNodes {
+		prefix := ""
+		if opts.ShowCluster {
+			prefix = pn.Cluster + ":"
+		}
+		id := func(parts ...string) string {
+			if pn.Cluster != "" {
+				parts = append([]string{pn.Cluster}, parts...)
+			}
+			return strings.Join(parts, "/")
+		}
+		namespaced := func(name string) string {
+			if opts.SubgraphBy == SubgraphNamespace {
+				return name
+			}
+			return pn.Pod.Namespace + "/" + name
+		}
+		zone := func(node *corev1.Node) string {
+			if node == nil {
+				return ""
+			}
+			return node.Labels[corev1.LabelTopologyZone]
+		}
+		group := func(namespace, zone string) string {
+			switch opts.SubgraphBy {
+			case SubgraphNamespace:
+				if namespace != "" {
+					return prefix + "namespace " + namespace
+				}
+			case SubgraphZone:
+				if zone != "" {
+					return prefix + "zone " + zone
+				}
+			}
+			return ""
+		}
+
+		pod := id("pod", pn.Pod.Namespace, pn.Pod.Name)
+		g.addVertex(pod, prefix+"pod/"+namespaced(pn.Pod.Name), group(pn.Pod.Namespace, zone(pn.Node)))
+
+		if pn.Node != nil {
+			node := id("node", pn.Node.Name)
+			g.addVertex(node, prefix+"node/"+pn.Node.Name, group("", zone(pn.Node)))
+			g.addEdge(pod, node, "runs on")
+		}
+		if sa := pn.ServiceAccount; sa != nil {
+			v := id("serviceaccount", sa.Namespace, sa.Name)
+			g.addVertex(v, prefix+"serviceaccount/"+namespaced(sa.Name), group(sa.Namespace, ""))
+			g.addEdge(pod, v, "uses")
+		}
+		for _, pvc := range pn.PVCs {
+			v := id("pvc", pvc.Namespace, pvc.Name)
+			g.addVertex(v, prefix+"pvc/"+namespaced(pvc.Name), group(pvc.Namespace, ""))
+			g.addEdge(pod, v, "mounts")
+			for _, pv := range pn.PVs {
+				if claim := pv.Spec.ClaimRef; claim != nil && claim.Namespace == pvc.Namespace && claim.Name == pvc.Name {
+					pvID := id("pv", pv.Name)
+					g.addVertex(pvID, prefix+"pv/"+pv.Name, group("", pv.Labels[corev1.LabelTopologyZone]))
+					g.addEdge(v, pvID, "bound to")
+				}
+			}
+		}
+
+		relations := make([]string, 0, len(pn.Related))
+		for name := range pn.Related {
+			relations = append(relations, name)
+		}
+		sort.Strings(relations)
+		for _, name := range relations {
+			obj, err := meta.Accessor(pn.Related[name])
+			if err != nil {
+				continue
+			}
+			v := id(name, obj.GetNamespace(), obj.GetName())
+			label := name + "/" + obj.GetName()
+			if obj.GetNamespace() != "" && opts.SubgraphBy != SubgraphNamespace {
+				label = name + "/" + obj.GetNamespace() + "/" + obj.GetName()
+			}
+			g.addVertex(v, prefix+label, group(obj.GetNamespace(), ""))
+			g.addEdge(pod, v, name)
+		}
+	}
+	return g
+}
+
+func (g *graph) addVertex(id, label, group string) {
+	if _, ok := g.byID[id]; ok {
+		return
+	}
+	v := &graphVertex{ID: id, Label: label, Group: group}
+	g.vertices = append(g.vertices, v)
+	g.byID[id] = v
+}
+
+func (g *graph) addEdge(from, to, label string) {
+	e := graphEdge{From: from, To: to, Label: label}
+	if g.seen[e] {
+		return
+	}
+	g.seen[e] = true
+	g.edges = append(g.edges, e)
+}
+
+// groups returns the subgraph names in order, with the vertices of each.
+// Vertices outside any subgraph are under the empty name, listed first.
+func (g *graph) groups() ([]string, map[string][]*graphVertex) {
+	members := make(map[string][]*graphVertex)
+	for _, v := range g.vertices {
+		members[v.Group] = append(members[v.Group], v)
+	}
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, members
+}
+
+// printDOT writes the graph in the Graphviz DOT language, subgraphs become clusters.
+func printDOT(out io.Writer, g *graph) error {
+	var b strings.Builder
+	b.WriteString("digraph wider {\n  rankdir=LR;\n  node [shape=box];\n")
+
+	names, members := g.groups()
+	for i, name := range names {
+		indent := "  "
+		if name != "" {
+			fmt.Fprintf(&b, "  subgraph cluster_%d {\n    label=%s;\n", i, dotQuote(name))
+			indent = "    "
+		}
+		for _, v := range members[name] {
+			fmt.Fprintf(&b, "%s%s [label=%s];\n", indent, dotQuote(v.ID), dotQuote(v.Label))
+		}
+		if name != "" {
+			b.WriteString("  }\n")
+		}
+	}
+	for _, e := range g.edges {
+		fmt.Fprintf(&b, "  %s -> %s [label=%s];\n", dotQuote(e.From), dotQuote(e.To), dotQuote(e.Label))
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// printMermaid writes the graph as a Mermaid flowchart. Mermaid identifiers
+// cannot hold the characters of object names, so vertices are numbered.
+func printMermaid(out io.Writer, g *graph) error {
+	ids := make(map[string]string)
+	for i, v := range g.vertices {
+		ids[v.ID] = fmt.Sprintf("v%d", i)
+	}
+
+	var b strings.Builder
+	b.WriteString("graph LR\n")
+
+	names, members := g.groups()
+	for i, name := range names {
+		indent := "  "
+		if name != "" {
+			fmt.Fprintf(&b, "  subgraph g%d[%s]\n", i, mermaidQuote(name))
+			indent = "    "
+		}
+		for _, v := range members[name] {
+			fmt.Fprintf(&b, "%s%s[%s]\n", indent, ids[v.ID], mermaidQuote(v.Label))
+		}
+		if name != "" {
+			b.WriteString("  end\n")
+		}
+	}
+	for _, e := range g.edges {
+		fmt.Fprintf(&b, "  %s -->|%s| %s\n", ids[e.From], mermaidQuote(e.Label), ids[e.To])
+	}
+
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+func mermaidQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+}
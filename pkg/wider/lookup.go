@@ -0,0 +1,148 @@
+package wider
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// DefaultGetThreshold is the number of related objects fetched one by one
+// before a relation falls back to listing them all.
+const DefaultGetThreshold = 20
+
+func getThreshold(threshold int) int {
+	if threshold == 0 {
+		return DefaultGetThreshold
+	}
+	return threshold
+}
+
+// objectRef names a namespaced object, Namespace is empty for cluster-scoped ones.
+type objectRef struct {
+	Namespace string
+	Name      string
+}
+
+func objectKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// lookup resolves the objects a relation joins, either by fetching the
+// referenced ones individually or with a single list, whichever is cheaper.
+// Small pod sets only reference a few objects, so fetching those avoids
+// listing every node or PVC in the cluster.
+//
+// A lookup shared by the loaders of several relations must only be read
+// through snapshot while the loaders run, objects is safe to read once they
+// are done. resolve holds the lookup while it waits for call slots, so it must
+// never be read or resolved from a call already holding a slot.
+type lookup[T any] struct {
+	req  LoadRequest
+	list func(ctx context.Context, objects map[string]T) error
+	get  func(ctx context.Context, ref objectRef) (T, error)
+
+	mu      sync.Mutex
+	objects map[string]T
+	listed  bool
+	fetched int
+}
+
+func newLookup[T any](req LoadRequest, list func(context.Context, map[string]T) error, get func(context.Context, objectRef) (T, error)) *lookup[T] {
+	return &lookup[T]{
+		req:     req,
+		list:    list,
+		get:     get,
+		objects: make(map[string]T),
+	}
+}
+
+// resolve makes sure every ref is in objects. Once more objects have been
+// referenced during the run than the threshold allows, the relation is listed
+// and only objects missing from the list are still fetched directly.
+func (l *lookup[T]) resolve(ctx context.Context, refs []objectRef) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	missing := l.missing(refs)
+	if l.listsFor(missing) {
+		if err := l.list(ctx, l.objects); err != nil {
+			return err
+		}
+		l.listed = true
+		missing = l.missing(missing)
+	}
+
+	if err := getMissing(ctx, l.req.calls, l.objects, missing, l.get); err != nil {
+		return err
+	}
+	l.fetched += len(missing)
+	return nil
+}
+
+// listsFor reports whether resolving the missing refs lists the relation.
+func (l *lookup[T]) listsFor(missing []objectRef) bool {
+	threshold := getThreshold(l.req.GetThreshold)
+	return !l.listed && (threshold < 0 || l.fetched+len(missing) > threshold)
+}
+
+// snapshot returns the resolved objects of refs keyed by objectKey, safe to
+// read while the lookup is resolved again.
+func (l *lookup[T]) snapshot(refs []objectRef) map[string]T {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	objects := make(map[string]T, len(refs))
+	for _, ref := range refs {
+		key := objectKey(ref.Namespace, ref.Name)
+		if obj, ok := l.objects[key]; ok {
+			objects[key] = obj
+		}
+	}
+	return objects
+}
+
+// missing returns the deduplicated refs not resolved yet.
+func (l *lookup[T]) missing(refs []objectRef) []objectRef {
+	seen := make(map[string]bool)
+	var missing []objectRef
+	for _, ref := range refs {
+		key := objectKey(ref.Namespace, ref.Name)
+		if _, ok := l.objects[key]; ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+		missing = append(missing, ref)
+	}
+	return missing
+}
+
+// getMissing fetches refs concurrently, bounded by calls, and adds them to
+// objects. Objects that do not exist are recorded as the zero value so the pod
+// is simply left without the relation and later pages do not retry it. Any
+// other error fails the relation like a failed list would.
+func getMissing[T any](ctx context.Context, calls callLimiter, objects map[string]T, refs []objectRef, get func(context.Context, objectRef) (T, error)) error {
+	var mu sync.Mutex
+	g, ctx := errgroup.WithContext(ctx)
+	for _, ref := range refs {
+		g.Go(func() error {
+			var obj T
+			err := calls.do(ctx, func() (err error) {
+				obj, err = get(ctx, ref)
+				return err
+			})
+			if apierrors.IsNotFound(err) {
+				var zero T
+				obj, err = zero, nil
+			}
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			objects[objectKey(ref.Namespace, ref.Name)] = obj
+			mu.Unlock()
+			return nil
+		})
+	}
+	return g.Wait()
+}
@@ -0,0 +1,43 @@
+package wider
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/metadata"
+)
+
+// metadataResource returns the metadata client for a resource, scoped to
+// namespace unless it is empty.
+func metadataResource(client metadata.Interface, gvr schema.GroupVersionResource, namespace string) metadata.ResourceInterface {
+	if namespace == "" {
+		return client.Resource(gvr)
+	}
+	return client.Resource(gvr).Namespace(namespace)
+}
+
+// listMetadata lists the metadata of a resource in namespace page by page and
+// hands every item to add. Cluster-scoped resources are listed with an empty namespace.
+func listMetadata(ctx context.Context, req LoadRequest, gvr schema.GroupVersionResource, namespace string, add func(meta metav1.ObjectMeta)) error {
+	resource := metadataResource(req.Clients.Metadata, gvr, namespace)
+	return req.listPages(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := resource.List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		for i := range list.Items {
+			add(list.Items[i].ObjectMeta)
+		}
+		return list.Continue, nil
+	})
+}
+
+// getMetadata returns the metadata of a single object.
+func getMetadata(ctx context.Context, req LoadRequest, gvr schema.GroupVersionResource, ref objectRef) (metav1.ObjectMeta, error) {
+	obj, err := metadataResource(req.Clients.Metadata, gvr, ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return metav1.ObjectMeta{}, err
+	}
+	return obj.ObjectMeta, nil
+}
@@ -0,0 +1,64 @@
+package wider
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// nodePressureConditions are the node conditions summarised by the wide output, with their column label.
+var nodePressureConditions = []struct {
+	condition corev1.NodeConditionType
+	label     string
+}{
+	{corev1.NodeMemoryPressure, "Memory"},
+	{corev1.NodeDiskPressure, "Disk"},
+	{corev1.NodePIDPressure, "PID"},
+}
+
+// nodeStatus summarises the Ready condition and cordon of a node like
+// kubectl get nodes does, e.g. Ready,SchedulingDisabled.
+func nodeStatus(node *corev1.Node) string {
+	status := "Unknown"
+	for _, condition := range node.Status.Conditions {
+		if condition.Type != corev1.NodeReady {
+			continue
+		}
+		// Like kubectl, a Ready condition that is not True, including
+		// Unknown, is NotReady
+		status = "NotReady"
+		if condition.Status == corev1.ConditionTrue {
+			status = "Ready"
+		}
+	}
+	if node.Spec.Unschedulable {
+		status += ",SchedulingDisabled"
+	}
+	return status
+}
+
+// nodePressure lists the pressure conditions of a node that are true, e.g. Memory,PID.
+func nodePressure(node *corev1.Node) string {
+	var pressures []string
+	for _, p := range nodePressureConditions {
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == p.condition && condition.Status == corev1.ConditionTrue {
+				pressures = append(pressures, p.label)
+			}
+		}
+	}
+	return noneIfEmpty(strings.Join(pressures, ","))
+}
+
+// nodeTaints lists the taints of a node as key=value:effect. The taint added
+// by cordoning is left out, it is already shown by nodeStatus.
+func nodeTaints(node *corev1.Node) string {
+	var taints []string
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == corev1.TaintNodeUnschedulable && node.Spec.Unschedulable {
+			continue
+		}
+		taints = append(taints, taint.ToString())
+	}
+	return noneIfEmpty(strings.Join(taints, ","))
+}
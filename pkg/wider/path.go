@@ -0,0 +1,266 @@
+package wider
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"reflect"
+	"strings"
+)
+
+// FormatAge renders the time elapsed since t like kubectl does.
+func FormatAge(t metav1.Time) string {
+	duration := metav1.Now().Sub(t.Time)
+
+	days := int(duration.Hours() / 24)
+	hours := int(duration.Hours())
+	minutes := int(duration.Minutes())
+	seconds := int(duration.Seconds())
+
+	// Show days only if >= 2 days
+	if days >= 2 {
+		return fmt.Sprintf("%dd", days)
+	} else if hours > 0 {
+		return fmt.Sprintf("%dh", hours)
+	} else if minutes > 0 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%ds", seconds)
+}
+
+// GetValueByPath evaluates a custom-columns style path such as
+// .node.metadata.labels.kubernetes\.io/os against an enriched pod,
+// resolving relation roots through DefaultRegistry.
+func GetValueByPath(pn PodWithWider, path string) (string, error) {
+	return DefaultRegistry.GetValueByPath(pn, path)
+}
+
+// builtinRoots are the path roots evaluated on the pod itself rather than a relation.
+var builtinRoots = []string{"cluster", "pod", "container", "resources"}
+
+// Path is a parsed custom-columns path. The path .node.metadata.labels.kubernetes\.io/os
+// has the root node and the fields metadata, labels and kubernetes.io/os.
+type Path struct {
+	Root   string
+	Fields []string
+}
+
+// ParsePath parses a custom-columns style path, the leading dot is optional.
+func ParsePath(path string) (Path, error) {
+	// Split by dots, but respect escaped dots
+	parts := splitPath(strings.TrimPrefix(path, "."))
+	if len(parts) == 0 {
+		return Path{}, fmt.Errorf("empty path")
+	}
+	return Path{Root: parts[0], Fields: parts[1:]}, nil
+}
+
+// GetValueByPath evaluates a custom-columns style path, resolving relation roots through r.
+func (r *Registry) GetValueByPath(pn PodWithWider, path string) (string, error) {
+	p, err := ParsePath(path)
+	if err != nil {
+		return "", err
+	}
+	return r.Evaluate(pn, p)
+}
+
+// Evaluate returns the value of a parsed path on an enriched pod.
+func (r *Registry) Evaluate(pn PodWithWider, p Path) (string, error) {
+	var current interface{}
+	parts := p.Fields
+
+	switch p.Root {
+	case "cluster":
+		current = pn.Cluster
+	case "pod":
+		current = pn.Pod
+	case "container":
+		if pn.Container == nil {
+			return "<none>", nil
+		}
+		current = pn.Container
+	case "resources":
+		current = ComputePodResources(pn)
+	default:
+		rel, ok := r.Lookup(p.Root)
+		if !ok {
+			return "", fmt.Errorf("path must start with one of %s, got: %s", strings.Join(append(builtinRoots, r.Roots()...), ", "), p.Root)
+		}
+		if pn.IsForbidden(rel.Name()) {
+			return "<forbidden>", nil
+		}
+		value, ok := rel.Value(pn)
+		if !ok {
+			return "<none>", nil
+		}
+		// For lists of objects and custom resources, return comma-separated names
+		// TODO: Could add array indexing support like pvcs[0].name
+		if len(parts) == 0 {
+			if names, ok := objectNames(value); ok {
+				return names, nil
+			}
+		}
+		// Walk custom resources through their content
+		if u, ok := value.(*unstructured.Unstructured); ok {
+			value = u.Object
+		}
+		current = value
+	}
+
+	if len(parts) == 0 {
+		return formatValue(current), nil
+	}
+
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+
+		val := reflect.ValueOf(current)
+
+		// Handle pointers
+		for val.Kind() == reflect.Ptr {
+			if val.IsNil() {
+				return "<none>", nil
+			}
+			val = val.Elem()
+		}
+
+		if !val.IsValid() {
+			return "", fmt.Errorf("invalid value at part %d (%s)", i, part)
+		}
+
+		// Handle map access (e.g., labels[key])
+		if val.Kind() == reflect.Map {
+			if val.Type().Key().Kind() != reflect.String {
+				return "", fmt.Errorf("cannot access key %s on map with %v keys", part, val.Type().Key())
+			}
+			// Keys may be named string types such as ResourceName
+			key := reflect.ValueOf(part).Convert(val.Type().Key())
+			mapVal := val.MapIndex(key)
+			if !mapVal.IsValid() {
+				return "<none>", nil
+			}
+			current = mapVal.Interface()
+			continue
+		}
+
+		if val.Kind() != reflect.Struct {
+			return "", fmt.Errorf("cannot access field %s on non-struct type %v", part, val.Kind())
+		}
+
+		// Try to find field by JSON tag first, then by capitalized name
+		field := findFieldByJSONTag(val, part)
+
+		if !field.IsValid() {
+			// Fallback to capitalized field name
+			fieldName := capitalizeFirst(part)
+			field = val.FieldByName(fieldName)
+		}
+
+		if !field.IsValid() {
+			return "", fmt.Errorf("field %s not found", part)
+		}
+
+		current = field.Interface()
+	}
+
+	return formatValue(current), nil
+}
+
+// formatValue renders a value, quantities such as resource limits are printed
+// like kubectl does and unset pointers as <none>.
+func formatValue(value interface{}) string {
+	if q, ok := value.(resource.Quantity); ok {
+		return q.String()
+	}
+	if val := reflect.ValueOf(value); val.Kind() == reflect.Ptr && val.IsNil() {
+		return "<none>"
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// objectNames joins the names of a slice of Kubernetes objects.
+func objectNames(value interface{}) (string, bool) {
+	if u, ok := value.(*unstructured.Unstructured); ok {
+		return u.GetName(), true
+	}
+
+	val := reflect.ValueOf(value)
+	if val.Kind() != reflect.Slice {
+		return "", false
+	}
+
+	names := []string{}
+	for i := 0; i < val.Len(); i++ {
+		obj, ok := val.Index(i).Interface().(metav1.Object)
+		if !ok {
+			return "", false
+		}
+		names = append(names, obj.GetName())
+	}
+	return strings.Join(names, ","), true
+}
+
+func splitPath(path string) []string {
+	var parts []string
+	var current strings.Builder
+	escaped := false
+
+	for i := 0; i < len(path); i++ {
+		if escaped {
+			// Add the character after backslash literally
+			current.WriteByte(path[i])
+			escaped = false
+		} else if path[i] == '\\' {
+			// Next character is escaped
+			escaped = true
+		} else if path[i] == '.' {
+			// Unescaped dot is a separator
+			if current.Len() > 0 {
+				parts = append(parts, current.String())
+				current.Reset()
+			}
+		} else {
+			current.WriteByte(path[i])
+		}
+	}
+
+	// Add the last part
+	if current.Len() > 0 {
+		parts = append(parts, current.String())
+	}
+
+	return parts
+}
+
+func findFieldByJSONTag(val reflect.Value, tagName string) reflect.Value {
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		jsonTag := field.Tag.Get("json")
+		if jsonTag == "" {
+			continue
+		}
+		// JSON tag format: "name,omitempty" or just "name"
+		tagParts := strings.Split(jsonTag, ",")
+		if len(tagParts) > 0 && tagParts[0] == tagName {
+			return val.Field(i)
+		}
+		// Look into embedded structs inlined in the JSON representation
+		if tagParts[0] == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if inner := findFieldByJSONTag(val.Field(i), tagName); inner.IsValid() {
+				return inner
+			}
+		}
+	}
+	return reflect.Value{}
+}
+
+func capitalizeFirst(s string) string {
+	if s == "" {
+		return ""
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
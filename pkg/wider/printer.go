@@ -0,0 +1,543 @@
+package wider
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"text/tabwriter"
+)
+
+// PrintOptions controls how enriched pods are rendered.
+type PrintOptions struct {
+	OutputFormat  string
+	AllNamespaces bool
+	ShowCluster   bool
+	// Containers prints one row per container, see ExpandContainers.
+	Containers bool
+	// Registry resolves custom-columns roots, DefaultRegistry when nil.
+	Registry *Registry
+	// GroupBy is a path whose values split markdown and html output into
+	// sections with a heading each, e.g. .node.metadata.labels.topology\.kubernetes\.io/zone.
+	GroupBy string
+	// SubgraphBy groups the vertices of dot and mermaid output by namespace
+	// or zone, see ValidateSubgraphBy.
+	SubgraphBy string
+}
+
+func (opts PrintOptions) registry() *Registry {
+	if opts.Registry == nil {
+		return DefaultRegistry
+	}
+	return opts.Registry
+}
+
+// ValidateOutputFormat reports whether format is a supported output format.
+func ValidateOutputFormat(format string) error {
+	if format != "" {
+		isValid := false
+
+		if IsStructuredOutput(format) || IsDocumentOutput(format) || IsGraphOutput(format) || format == "wide" || format == "name" || format == "resources" {
+			isValid = true
+		} else if _, _, ok := ColumnsOutput(format); ok {
+			isValid = true
+		}
+
+		if !isValid {
+			return fmt.Errorf("unsupported output format: %s (supported: json, yaml, jsonl, ndjson, wide, name, resources, markdown, html, dot, mermaid, custom-columns=..., csv=..., tsv=..., markdown=..., html=...)", format)
+		}
+	}
+	return nil
+}
+
+// ColumnsOutput splits an output format taking custom-columns definitions,
+// such as csv=NAME:.pod.metadata.name, into its kind (custom-columns, csv,
+// tsv, markdown or html) and the definitions. ok is false for other formats.
+func ColumnsOutput(format string) (kind, columns string, ok bool) {
+	kind, columns, found := strings.Cut(format, "=")
+	if !found {
+		return "", "", false
+	}
+	switch kind {
+	case "custom-columns", "csv", "tsv", "markdown", "html":
+		return kind, columns, true
+	}
+	return "", "", false
+}
+
+// IsStructuredOutput reports whether format encodes whole enriched pods,
+// as opposed to the table formats printing selected columns.
+func IsStructuredOutput(format string) bool {
+	switch format {
+	case "json", "yaml", "jsonl", "ndjson":
+		return true
+	}
+	return false
+}
+
+// Printer renders enriched pods page by page.
+type Printer interface {
+	// PrintPage renders a page of pods, table formats write it straight away.
+	PrintPage(podNodes []PodWithWider) error
+	// Flush completes the output, structured formats are encoded here.
+	Flush() error
+}
+
+// NewPrinter returns a Printer writing to w in the format selected by opts.
+func NewPrinter(w io.Writer, opts PrintOptions) (Printer, error) {
+	if IsDocumentOutput(opts.OutputFormat) {
+		return newDocumentPrinter(w, opts)
+	} else if kind, _, ok := ColumnsOutput(opts.OutputFormat); ok && kind != "custom-columns" {
+		return newDelimitedPrinter(w, opts, kind)
+	} else if ok {
+		return newCustomColumnsPrinter(w, opts)
+	} else if opts.OutputFormat == "json" {
+		return &bufferedPrinter{print: func(podNodes []PodWithWider) error { return printJSON(w, NewPodWithWiderList(podNodes)) }}, nil
+	} else if opts.OutputFormat == "yaml" {
+		return &bufferedPrinter{print: func(podNodes []PodWithWider) error { return printYAML(w, NewPodWithWiderList(podNodes)) }}, nil
+	} else if opts.OutputFormat == "jsonl" || opts.OutputFormat == "ndjson" {
+		return &jsonLinesPrinter{encoder: json.NewEncoder(w)}, nil
+	} else if opts.OutputFormat == "dot" {
+		return &bufferedPrinter{print: func(podNodes []PodWithWider) error { return printDOT(w, buildGraph(opts, podNodes)) }}, nil
+	} else if opts.OutputFormat == "mermaid" {
+		return &bufferedPrinter{print: func(podNodes []PodWithWider) error { return printMermaid(w, buildGraph(opts, podNodes)) }}, nil
+	} else if opts.OutputFormat == "name" {
+		return &namePrinter{out: w}, nil
+	} else if opts.OutputFormat == "resources" {
+		return newResourcesPrinter(w, opts), nil
+	}
+
+	if opts.Containers {
+		return newContainerPrinter(w, opts), nil
+	}
+	return newDefaultPrinter(w, opts), nil
+}
+
+// Print writes pods to w in the format selected by opts.
+func Print(w io.Writer, opts PrintOptions, podNodes []PodWithWider) error {
+	printer, err := NewPrinter(w, opts)
+	if err != nil {
+		return err
+	}
+	if err := printer.PrintPage(podNodes); err != nil {
+		return err
+	}
+	return printer.Flush()
+}
+
+// bufferedPrinter collects every page and renders them together on Flush.
+type bufferedPrinter struct {
+	print    func(podNodes []PodWithWider) error
+	podNodes []PodWithWider
+}
+
+func (p *bufferedPrinter) PrintPage(podNodes []PodWithWider) error {
+	p.podNodes = append(p.podNodes, podNodes...)
+	return nil
+}
+
+func (p *bufferedPrinter) Flush() error {
+	return p.print(p.podNodes)
+}
+
+// tablePrinter writes aligned rows as pages arrive. Columns are aligned
+// within a page, like kubectl does with --chunk-size.
+type tablePrinter struct {
+	out           io.Writer
+	headers       []string
+	row           func(pn PodWithWider) []string
+	headerPrinted bool
+}
+
+func (p *tablePrinter) PrintPage(podNodes []PodWithWider) error {
+	w := tabwriter.NewWriter(p.out, 0, 0, 3, ' ', 0)
+
+	// Print headers
+	if !p.headerPrinted {
+		fmt.Fprintln(w, strings.Join(p.headers, "\t"))
+		p.headerPrinted = true
+	}
+
+	// Print rows
+	for _, pn := range podNodes {
+		fmt.Fprintln(w, strings.Join(p.row(pn), "\t"))
+	}
+
+	return w.Flush()
+}
+
+func (p *tablePrinter) Flush() error {
+	if p.headerPrinted {
+		return nil
+	}
+	return p.PrintPage(nil)
+}
+
+// delimitedPrinter writes custom columns as RFC 4180 CSV with CRLF line
+// endings, or tab separated with the same quoting and LF line endings, with a
+// header row. Values are not padded so they can be loaded into spreadsheets.
+type delimitedPrinter struct {
+	writer        *csv.Writer
+	headers       []string
+	row           func(pn PodWithWider) []string
+	headerPrinted bool
+}
+
+func newDelimitedPrinter(out io.Writer, opts PrintOptions, kind string) (Printer, error) {
+	headers, paths, err := parseCustomColumns(opts.OutputFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := csv.NewWriter(out)
+	if kind == "tsv" {
+		writer.Comma = '\t'
+	} else {
+		writer.UseCRLF = true
+	}
+	return &delimitedPrinter{
+		writer:  writer,
+		headers: headers,
+		row:     customColumnsRow(opts.registry(), paths),
+	}, nil
+}
+
+func (p *delimitedPrinter) PrintPage(podNodes []PodWithWider) error {
+	if !p.headerPrinted {
+		if err := p.writer.Write(p.headers); err != nil {
+			return err
+		}
+		p.headerPrinted = true
+	}
+	for _, pn := range podNodes {
+		if err := p.writer.Write(p.row(pn)); err != nil {
+			return err
+		}
+	}
+	p.writer.Flush()
+	return p.writer.Error()
+}
+
+func (p *delimitedPrinter) Flush() error {
+	if p.headerPrinted {
+		return nil
+	}
+	return p.PrintPage(nil)
+}
+
+// jsonLinesPrinter writes one enriched pod per line as pages arrive, for
+// consumers like jq -c and log pipelines.
+type jsonLinesPrinter struct {
+	encoder *json.Encoder
+}
+
+func (p *jsonLinesPrinter) PrintPage(podNodes []PodWithWider) error {
+	for _, pn := range podNodes {
+		if err := p.encoder.Encode(pn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *jsonLinesPrinter) Flush() error {
+	return nil
+}
+
+// namePrinter writes resource/name lines like kubectl -o name, for use in shell pipelines.
+type namePrinter struct {
+	out io.Writer
+}
+
+func (p *namePrinter) PrintPage(podNodes []PodWithWider) error {
+	for _, pn := range podNodes {
+		if _, err := fmt.Fprintf(p.out, "pod/%s\n", pn.Pod.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *namePrinter) Flush() error {
+	return nil
+}
+
+func printJSON(out io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func printYAML(out io.Writer, v interface{}) error {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal to YAML: %w", err)
+	}
+	fmt.Fprintln(out, string(data))
+	return nil
+}
+
+func newCustomColumnsPrinter(out io.Writer, opts PrintOptions) (Printer, error) {
+	headers, paths, err := parseCustomColumns(opts.OutputFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tablePrinter{
+		out:     out,
+		headers: headers,
+		row:     customColumnsRow(opts.registry(), paths),
+	}, nil
+}
+
+// customColumnsRow returns a row function evaluating paths against each pod.
+func customColumnsRow(registry *Registry, paths []Path) func(pn PodWithWider) []string {
+	return func(pn PodWithWider) []string {
+		var values []string
+		for _, path := range paths {
+			val, err := registry.Evaluate(pn, path)
+			if err != nil {
+				values = append(values, "<none>")
+			} else {
+				values = append(values, val)
+			}
+		}
+		return values
+	}
+}
+
+// parseCustomColumns returns the headers and parsed paths of a custom-columns, csv or tsv output format.
+func parseCustomColumns(outputFormat string) (headers []string, paths []Path, err error) {
+	kind, columnsStr, _ := ColumnsOutput(outputFormat)
+	columnDefs := strings.Split(columnsStr, ",")
+
+	for _, def := range columnDefs {
+		parts := strings.SplitN(def, ":", 2)
+		if len(parts) != 2 {
+			return nil, nil, fmt.Errorf("invalid %s format: %s", kind, def)
+		}
+		path, err := ParsePath(parts[1])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s path %q: %w", kind, def, err)
+		}
+		headers = append(headers, parts[0])
+		paths = append(paths, path)
+	}
+	return headers, paths, nil
+}
+
+// OutputPaths returns the paths read by an output format. Formats rendering
+// whole objects, such as json and yaml, read no paths.
+func OutputPaths(outputFormat string) ([]Path, error) {
+	if _, _, ok := ColumnsOutput(outputFormat); !ok {
+		return nil, nil
+	}
+	_, paths, err := parseCustomColumns(outputFormat)
+	return paths, err
+}
+
+func newDefaultPrinter(out io.Writer, opts PrintOptions) Printer {
+	return &tablePrinter{
+		out:     out,
+		headers: defaultHeaders(opts),
+		row: func(pn PodWithWider) []string {
+			return defaultRow(opts, pn)
+		},
+	}
+}
+
+// defaultHeaders returns the headers of the default table, matching defaultRow.
+func defaultHeaders(opts PrintOptions) []string {
+	var headers []string
+	if opts.ShowCluster {
+		headers = append(headers, "CLUSTER")
+	}
+	if opts.AllNamespaces {
+		headers = append(headers, "NAMESPACE")
+	}
+	headers = append(headers, "NAME", "READY", "STATUS", "RESTARTS", "AGE", "IP", "NODE")
+	if opts.OutputFormat == "wide" {
+		headers = append(headers, "NOMINATED NODE", "READINESS GATES", "OWNER", "SERVICE ACCOUNT", "PVCS")
+		headers = append(headers, "ZONE", "INSTANCE TYPE", "NODE STATUS", "PRESSURE", "TAINTS")
+	}
+	return headers
+}
+
+func defaultRow(opts PrintOptions, pn PodWithWider) []string {
+	pod := pn.Pod
+
+	// Calculate READY (ready/total containers)
+	totalContainers := len(pod.Spec.Containers)
+	readyContainers := 0
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Ready {
+			readyContainers++
+		}
+	}
+	ready := fmt.Sprintf("%d/%d", readyContainers, totalContainers)
+
+	// Get STATUS
+	status := string(pod.Status.Phase)
+	if pod.DeletionTimestamp != nil {
+		status = "Terminating"
+	}
+
+	// Calculate RESTARTS
+	restarts := 0
+	for _, cs := range pod.Status.ContainerStatuses {
+		restarts += int(cs.RestartCount)
+	}
+
+	// Calculate AGE
+	age := FormatAge(pod.CreationTimestamp)
+
+	// Get NODE info
+	nodeName := pod.Spec.NodeName
+	nodeIP := ""
+	if pn.IsForbidden("node") {
+		nodeIP = "<forbidden>"
+	} else if pn.Node != nil {
+		for _, addr := range pn.Node.Status.Addresses {
+			if addr.Type == corev1.NodeInternalIP {
+				nodeIP = addr.Address
+				break
+			}
+		}
+	}
+
+	var values []string
+	if opts.ShowCluster {
+		values = append(values, pn.Cluster)
+	}
+	if opts.AllNamespaces {
+		values = append(values, pod.Namespace)
+	}
+	values = append(values,
+		pod.Name,
+		ready,
+		status,
+		fmt.Sprintf("%d", restarts),
+		age,
+		nodeIP,
+		nodeName)
+	if opts.OutputFormat == "wide" {
+		values = append(values, podWideColumns(pod)...)
+		values = append(values, nodeWideColumns(pn)...)
+	}
+	return values
+}
+
+// podWideColumns returns the pod columns added by the wide output, the
+// nominated node and readiness gates as kubectl shows them, then the
+// controller, service account and claims read from the pod spec.
+func podWideColumns(pod *corev1.Pod) []string {
+	readinessGates := "<none>"
+	if len(pod.Spec.ReadinessGates) > 0 {
+		ready := 0
+		for _, gate := range pod.Spec.ReadinessGates {
+			for _, condition := range pod.Status.Conditions {
+				if condition.Type == gate.ConditionType && condition.Status == corev1.ConditionTrue {
+					ready++
+					break
+				}
+			}
+		}
+		readinessGates = fmt.Sprintf("%d/%d", ready, len(pod.Spec.ReadinessGates))
+	}
+
+	owner := "<none>"
+	if ref := metav1.GetControllerOf(pod); ref != nil {
+		owner = ref.Kind + "/" + ref.Name
+	}
+
+	claims := podClaims(pod)
+
+	return []string{
+		noneIfEmpty(pod.Status.NominatedNodeName),
+		readinessGates,
+		owner,
+		noneIfEmpty(pod.Spec.ServiceAccountName),
+		noneIfEmpty(strings.Join(claims, ",")),
+	}
+}
+
+// nodeWideColumns returns the node columns added by the wide output.
+func nodeWideColumns(pn PodWithWider) []string {
+	const columns = 5
+	placeholder := "<none>"
+	if pn.IsForbidden("node") {
+		placeholder = "<forbidden>"
+	}
+	if pn.Node == nil {
+		values := make([]string, columns)
+		for i := range values {
+			values[i] = placeholder
+		}
+		return values
+	}
+
+	node := pn.Node
+	return []string{
+		noneIfEmpty(node.Labels[corev1.LabelTopologyZone]),
+		noneIfEmpty(node.Labels[corev1.LabelInstanceTypeStable]),
+		nodeStatus(node),
+		nodePressure(node),
+		nodeTaints(node),
+	}
+}
+
+func newContainerPrinter(out io.Writer, opts PrintOptions) Printer {
+	var headers []string
+	if opts.ShowCluster {
+		headers = append(headers, "CLUSTER")
+	}
+	if opts.AllNamespaces {
+		headers = append(headers, "NAMESPACE")
+	}
+	headers = append(headers, "POD", "CONTAINER", "TYPE", "IMAGE", "READY", "STATE", "RESTARTS", "LAST TERMINATION", "NODE")
+
+	return &tablePrinter{
+		out:     out,
+		headers: headers,
+		row: func(pn PodWithWider) []string {
+			return containerRow(opts, pn)
+		},
+	}
+}
+
+func containerRow(opts PrintOptions, pn PodWithWider) []string {
+	c := ContainerWithStatus{}
+	if pn.Container != nil {
+		c = *pn.Container
+	}
+
+	ready := "false"
+	restarts := 0
+	if c.Status != nil {
+		ready = fmt.Sprintf("%t", c.Status.Ready)
+		restarts = int(c.Status.RestartCount)
+	}
+
+	var values []string
+	if opts.ShowCluster {
+		values = append(values, pn.Cluster)
+	}
+	if opts.AllNamespaces {
+		values = append(values, pn.Pod.Namespace)
+	}
+	values = append(values,
+		pn.Pod.Name,
+		c.Name,
+		c.Type,
+		c.Image,
+		ready,
+		ContainerState(c),
+		fmt.Sprintf("%d", restarts),
+		LastTermination(c),
+		pn.Pod.Spec.NodeName)
+	return values
+}
@@ -0,0 +1,308 @@
+package wider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+)
+
+// Clients holds the API clients relations load from.
+type Clients struct {
+	Kubernetes kubernetes.Interface
+	// Dynamic is only required by relations backed by custom resources.
+	Dynamic dynamic.Interface
+	// Metadata fetches object metadata only, relations fall back to full
+	// objects when it is nil.
+	Metadata metadata.Interface
+}
+
+// LoadRequest describes the enrichment run a relation is loaded for.
+type LoadRequest struct {
+	Clients Clients
+	// Namespace the pods are listed in, empty for all namespaces.
+	Namespace string
+	// Concurrency is the number of API calls made in parallel during the run,
+	// shared by every relation through the request's call limiter.
+	Concurrency int
+	// ChunkSize is the page size for list calls, zero lists everything at once.
+	ChunkSize int64
+	// GetThreshold is the number of referenced objects fetched one by one before
+	// listing them all, DefaultGetThreshold when zero and always list when negative.
+	GetThreshold int
+	// MetadataOnly reports that only the object metadata of the relation is read,
+	// relations may then fetch PartialObjectMetadata instead of full objects.
+	MetadataOnly bool
+	// Cache keeps cluster-scoped lists between runs, nil disables it.
+	Cache *Cache
+
+	// calls is shared by the loaders of a run so that Concurrency bounds
+	// the API calls of all relations together.
+	calls callLimiter
+	// claims is the PVC lookup shared by the loaders of a run, see claimLookup.
+	claims *sharedClaims
+}
+
+// callLimiter is a semaphore bounding the API calls made in parallel, a nil
+// limiter does not bound them.
+type callLimiter chan struct{}
+
+func newCallLimiter(limit int) callLimiter {
+	return make(callLimiter, concurrencyLimit(limit))
+}
+
+// do waits for a free slot and makes the API call.
+func (l callLimiter) do(ctx context.Context, call func() error) error {
+	if l == nil {
+		return call()
+	}
+	select {
+	case l <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-l }()
+	return call()
+}
+
+// listPages lists a collection page by page like the package level
+// listPages, every page taking a slot of the call limiter.
+func (req LoadRequest) listPages(ctx context.Context, list func(opts metav1.ListOptions) (string, error)) error {
+	return listPages(ctx, req.ChunkSize, func(opts metav1.ListOptions) (next string, err error) {
+		err = req.calls.do(ctx, func() error {
+			next, err = list(opts)
+			return err
+		})
+		return next, err
+	})
+}
+
+// JoinFunc attaches a loaded relation to a single pod.
+type JoinFunc func(pn *PodWithWider)
+
+// Loader loads a relation for the successive pages of pods of one enrichment run.
+type Loader interface {
+	// Load fetches what the relation needs for a page of pods and returns how to join it to each of them.
+	Load(ctx context.Context, pods []corev1.Pod) (JoinFunc, error)
+}
+
+// LoaderFunc adapts a function to the Loader interface.
+type LoaderFunc func(ctx context.Context, pods []corev1.Pod) (JoinFunc, error)
+
+// Load calls f.
+func (f LoaderFunc) Load(ctx context.Context, pods []corev1.Pod) (JoinFunc, error) {
+	return f(ctx, pods)
+}
+
+// Relation attaches a related resource to pods.
+type Relation interface {
+	// Name identifies the relation, custom relations are stored under it in PodWithWider.Related.
+	Name() string
+	// Roots lists the path roots resolving to the relation, e.g. "serviceAccount" and "sa".
+	Roots() []string
+	// NewLoader returns the loader for one enrichment run. Loaders keep what they
+	// fetched across pages so cluster-wide lists are made once per run.
+	NewLoader(req LoadRequest) Loader
+	// Value returns the object the roots resolve to and whether the pod has one.
+	Value(pn PodWithWider) (interface{}, bool)
+}
+
+// Registry holds the relations available to the enricher and the path evaluator.
+type Registry struct {
+	relations []Relation
+	roots     map[string]Relation
+}
+
+// NewRegistry returns a registry with the given relations.
+func NewRegistry(relations ...Relation) *Registry {
+	r := &Registry{roots: make(map[string]Relation)}
+	for _, rel := range relations {
+		r.Register(rel)
+	}
+	return r
+}
+
+// DefaultRegistry holds the built-in node, service account, PVC and PV relations.
+var DefaultRegistry = NewRegistry(nodeRelation{}, serviceAccountRelation{}, pvcRelation{}, pvRelation{})
+
+// Register adds a relation to the default registry.
+func Register(rel Relation) {
+	DefaultRegistry.Register(rel)
+}
+
+// Register adds a relation, replacing any earlier relation with the same name.
+func (r *Registry) Register(rel Relation) {
+	for i, existing := range r.relations {
+		if existing.Name() == rel.Name() {
+			for _, root := range existing.Roots() {
+				delete(r.roots, root)
+			}
+			r.relations = append(r.relations[:i], r.relations[i+1:]...)
+			break
+		}
+	}
+
+	r.relations = append(r.relations, rel)
+	for _, root := range rel.Roots() {
+		r.roots[root] = rel
+	}
+}
+
+// Relations returns the registered relations in registration order.
+func (r *Registry) Relations() []Relation {
+	return append([]Relation(nil), r.relations...)
+}
+
+// Lookup returns the relation a path root resolves to.
+func (r *Registry) Lookup(root string) (Relation, bool) {
+	rel, ok := r.roots[root]
+	return rel, ok
+}
+
+// Get returns the relation registered under name.
+func (r *Registry) Get(name string) (Relation, bool) {
+	for _, rel := range r.relations {
+		if rel.Name() == name {
+			return rel, true
+		}
+	}
+	return nil, false
+}
+
+// Roots returns every root the registry resolves, sorted.
+func (r *Registry) Roots() []string {
+	var roots []string
+	for root := range r.roots {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// RelationsForOutput returns the names of the relations an output format needs.
+// Structured and graph outputs need every relation, custom columns the
+// relations their path roots resolve to.
+func (r *Registry) RelationsForOutput(outputFormat string) []string {
+	if IsStructuredOutput(outputFormat) || IsGraphOutput(outputFormat) {
+		return r.names(func(Relation) bool { return true })
+	}
+	switch outputFormat {
+	case "", "wide", "markdown", "html", "resources":
+		// The default table shows the node IP, wide its labels, conditions
+		// and taints, resources its allocatable
+		return r.names(func(rel Relation) bool { return rel.Name() == "node" })
+	case "name":
+		return nil
+	}
+
+	paths, err := OutputPaths(outputFormat)
+	if err != nil {
+		return nil
+	}
+	return r.RelationsForPaths(paths)
+}
+
+// RelationsForPaths returns the names of the relations the path roots resolve to.
+func (r *Registry) RelationsForPaths(paths []Path) []string {
+	needed := make(map[string]bool)
+	for _, path := range paths {
+		if path.Root == "resources" {
+			needed["node"] = true
+		}
+		if rel, ok := r.Lookup(path.Root); ok {
+			needed[rel.Name()] = true
+		}
+	}
+	return r.names(func(rel Relation) bool { return needed[rel.Name()] })
+}
+
+// RelationsFor returns the names of the relations to load for an output format
+// and the relations explicitly included by name or root. Included relations
+// are added to those the output needs, except for structured and graph
+// outputs which then only load the included relations.
+func (r *Registry) RelationsFor(outputFormat string, include []string) ([]string, error) {
+	if len(include) == 0 {
+		return r.RelationsForOutput(outputFormat), nil
+	}
+
+	included := make(map[string]bool)
+	for _, name := range include {
+		rel, ok := r.Get(name)
+		if !ok {
+			rel, ok = r.Lookup(name)
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown relation: %s (known: %s)", name, strings.Join(r.Roots(), ", "))
+		}
+		included[rel.Name()] = true
+	}
+	if !IsStructuredOutput(outputFormat) && !IsGraphOutput(outputFormat) {
+		for _, name := range r.RelationsForOutput(outputFormat) {
+			included[name] = true
+		}
+	}
+	return r.names(func(rel Relation) bool { return included[rel.Name()] }), nil
+}
+
+// MetadataOnlyForOutput returns the names of the relations whose values are
+// only read under metadata by an output format.
+func (r *Registry) MetadataOnlyForOutput(outputFormat string) []string {
+	paths, err := OutputPaths(outputFormat)
+	if err != nil {
+		return nil
+	}
+	return r.MetadataOnlyForPaths(paths)
+}
+
+// MetadataOnlyForPaths returns the names of the relations every path reading
+// them reads under metadata.
+func (r *Registry) MetadataOnlyForPaths(paths []Path) []string {
+	metadataOnly := make(map[string]bool)
+	for _, path := range paths {
+		if path.Root == "resources" {
+			// Allocatable is read from the node status
+			metadataOnly["node"] = false
+			continue
+		}
+		rel, ok := r.Lookup(path.Root)
+		if !ok {
+			continue
+		}
+		// A bare root prints the whole object
+		only := len(path.Fields) > 0 && path.Fields[0] == "metadata"
+		if seen, ok := metadataOnly[rel.Name()]; ok {
+			only = only && seen
+		}
+		metadataOnly[rel.Name()] = only
+	}
+	return r.names(func(rel Relation) bool { return metadataOnly[rel.Name()] })
+}
+
+// names returns the names of the relations matching keep in registration order.
+func (r *Registry) names(keep func(rel Relation) bool) []string {
+	var names []string
+	for _, rel := range r.relations {
+		if keep(rel) {
+			names = append(names, rel.Name())
+		}
+	}
+	return names
+}
+
+func (r *Registry) resolve(names []string) ([]Relation, error) {
+	var relations []Relation
+	for _, name := range names {
+		rel, ok := r.Get(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown relation: %s", name)
+		}
+		relations = append(relations, rel)
+	}
+	return relations, nil
+}
@@ -0,0 +1,290 @@
+package wider
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Reports supported by PrintReport.
+const (
+	// ReportImages aggregates the container images of the selected pods.
+	ReportImages = "images"
+	// ReportTopology shows how the pods of every workload spread across zones, regions and nodes.
+	ReportTopology = "topology"
+)
+
+// ValidateReport reports whether name is a supported report.
+func ValidateReport(name string) error {
+	switch name {
+	case "", ReportImages, ReportTopology:
+		return nil
+	}
+	return fmt.Errorf("unsupported report: %s (supported: %s, %s)", name, ReportImages, ReportTopology)
+}
+
+// ReportRelations returns the names of the relations a report needs.
+func ReportRelations(name string) []string {
+	switch name {
+	case ReportImages:
+		// Node status lists the images present on the node
+		return []string{"node"}
+	case ReportTopology:
+		// Zones and regions are node labels
+		return []string{"node"}
+	}
+	return nil
+}
+
+// PrintReport aggregates pods into the named report and writes it to w as a
+// table, or as json or yaml when opts.OutputFormat selects them.
+func PrintReport(w io.Writer, name string, opts PrintOptions, podNodes []PodWithWider) error {
+	switch name {
+	case ReportImages:
+		usages := ImageInventory(podNodes)
+		switch opts.OutputFormat {
+		case "json":
+			return printJSON(w, usages)
+		case "yaml":
+			return printYAML(w, usages)
+		}
+		return printImageInventory(w, opts, usages)
+	case ReportTopology:
+		spreads := TopologySpread(podNodes)
+		switch opts.OutputFormat {
+		case "json":
+			return printJSON(w, spreads)
+		case "yaml":
+			return printYAML(w, spreads)
+		}
+		return printTopologySpread(w, opts, spreads)
+	}
+	return ValidateReport(name)
+}
+
+// ImageUsage is where a container image runs across the selected pods.
+type ImageUsage struct {
+	Image string `json:"image"`
+	// Digests are the image digests reported by the container statuses.
+	Digests    []string `json:"digests,omitempty"`
+	Clusters   []string `json:"clusters,omitempty"`
+	Namespaces []string `json:"namespaces"`
+	Pods       int      `json:"pods"`
+	Containers int      `json:"containers"`
+	// Nodes are the nodes running the image, prefixed with the cluster when
+	// the pods come from several clusters.
+	Nodes []string `json:"nodes,omitempty"`
+	// PresentOn are the nodes running the image that list it in status.images.
+	PresentOn []string `json:"presentOn,omitempty"`
+	// SizeBytes is the image size reported by the nodes, zero when unknown.
+	SizeBytes int64 `json:"sizeBytes,omitempty"`
+}
+
+// ImageInventory aggregates the images of every container of the pods, sorted by image.
+func ImageInventory(podNodes []PodWithWider) []ImageUsage {
+	type usage struct {
+		ImageUsage
+		digests, clusters, namespaces, pods, nodes, presentOn map[string]bool
+	}
+	images := make(map[string]*usage)
+
+	for _, pn := range podNodes {
+		for _, c := range PodContainers(pn.Pod) {
+			u := images[c.Image]
+			if u == nil {
+				u = &usage{
+					ImageUsage: ImageUsage{Image: c.Image},
+					digests:    make(map[string]bool),
+					clusters:   make(map[string]bool),
+					namespaces: make(map[string]bool),
+					pods:       make(map[string]bool),
+					nodes:      make(map[string]bool),
+					presentOn:  make(map[string]bool),
+				}
+				images[c.Image] = u
+			}
+
+			u.Containers++
+			u.pods[pn.Cluster+"/"+pn.Pod.Namespace+"/"+pn.Pod.Name] = true
+			u.namespaces[pn.Pod.Namespace] = true
+			if pn.Cluster != "" {
+				u.clusters[pn.Cluster] = true
+			}
+			imageID := ""
+			if c.Status != nil {
+				imageID = c.Status.ImageID
+				if digest := imageDigest(imageID); digest != "" {
+					u.digests[digest] = true
+				}
+			}
+
+			nodeName := pn.Pod.Spec.NodeName
+			if nodeName == "" {
+				continue
+			}
+			node := pn.Cluster + "/" + nodeName
+			u.nodes[node] = true
+			if pn.Node == nil {
+				continue
+			}
+			if size, ok := nodeImageSize(pn.Node.Status.Images, c.Image, imageID); ok {
+				u.presentOn[node] = true
+				if size > u.SizeBytes {
+					u.SizeBytes = size
+				}
+			}
+		}
+	}
+
+	var usages []ImageUsage
+	for _, u := range images {
+		u.Digests = sortedKeys(u.digests)
+		u.Clusters = sortedKeys(u.clusters)
+		u.Namespaces = sortedKeys(u.namespaces)
+		u.Pods = len(u.pods)
+		u.Nodes = nodeNames(u.nodes, len(u.clusters) > 1)
+		u.PresentOn = nodeNames(u.presentOn, len(u.clusters) > 1)
+		usages = append(usages, u.ImageUsage)
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].Image < usages[j].Image
+	})
+	return usages
+}
+
+// imageDigest returns the sha256 digest of an image ID such as
+// docker-pullable://nginx@sha256:... or sha256:...
+func imageDigest(imageID string) string {
+	if i := strings.LastIndex(imageID, "@"); i >= 0 {
+		return imageID[i+1:]
+	}
+	if strings.HasPrefix(imageID, "sha256:") {
+		return imageID
+	}
+	return ""
+}
+
+// nodeImageSize returns the size of an image listed in a node's status. Images
+// are matched on their repository digest, then on the normalized image name.
+func nodeImageSize(nodeImages []corev1.ContainerImage, image, imageID string) (int64, bool) {
+	candidates := map[string]bool{
+		image:                 true,
+		normalizeImage(image): true,
+	}
+	if ref := strings.TrimPrefix(imageID, "docker-pullable://"); strings.Contains(ref, "@") {
+		candidates[ref] = true
+		candidates[normalizeImage(ref)] = true
+	}
+
+	for _, nodeImage := range nodeImages {
+		for _, name := range nodeImage.Names {
+			if candidates[name] {
+				return nodeImage.SizeBytes, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// normalizeImage expands an image reference the way container runtimes do,
+// e.g. nginx becomes docker.io/library/nginx:latest.
+func normalizeImage(image string) string {
+	name, digest, hasDigest := strings.Cut(image, "@")
+
+	domain, remainder, found := strings.Cut(name, "/")
+	if !found || (!strings.ContainsAny(domain, ".:") && domain != "localhost") {
+		domain, remainder = "docker.io", name
+	}
+	if domain == "docker.io" && !strings.Contains(remainder, "/") {
+		remainder = "library/" + remainder
+	}
+	name = domain + "/" + remainder
+
+	if hasDigest {
+		return name + "@" + digest
+	}
+	if !strings.Contains(remainder, ":") {
+		name += ":latest"
+	}
+	return name
+}
+
+func printImageInventory(out io.Writer, opts PrintOptions, usages []ImageUsage) error {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+
+	headers := []string{"IMAGE"}
+	if opts.ShowCluster {
+		headers = append(headers, "CLUSTERS")
+	}
+	headers = append(headers, "NAMESPACES", "PODS", "CONTAINERS", "NODES", "PRESENT", "SIZE", "DIGESTS")
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+
+	for _, u := range usages {
+		values := []string{u.Image}
+		if opts.ShowCluster {
+			values = append(values, strings.Join(u.Clusters, ","))
+		}
+		values = append(values,
+			strings.Join(u.Namespaces, ","),
+			fmt.Sprintf("%d", u.Pods),
+			fmt.Sprintf("%d", u.Containers),
+			fmt.Sprintf("%d", len(u.Nodes)),
+			fmt.Sprintf("%d/%d", len(u.PresentOn), len(u.Nodes)),
+			formatBytes(u.SizeBytes),
+			noneIfEmpty(strings.Join(u.Digests, ",")))
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+
+	return w.Flush()
+}
+
+// formatBytes renders a size in decimal units like container runtimes do.
+func formatBytes(size int64) string {
+	if size <= 0 {
+		return "<none>"
+	}
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1000 && unit < len(units)-1 {
+		value /= 1000
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d%s", size, units[unit])
+	}
+	return fmt.Sprintf("%.1f%s", value, units[unit])
+}
+
+func noneIfEmpty(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
+
+// nodeNames returns the sorted names of cluster/node keys, keeping the
+// cluster only when it is needed to tell nodes apart.
+func nodeNames(nodes map[string]bool, withCluster bool) []string {
+	var names []string
+	for _, key := range sortedKeys(nodes) {
+		if !withCluster {
+			_, key, _ = strings.Cut(key, "/")
+		}
+		names = append(names, key)
+	}
+	return names
+}
+
+func sortedKeys(set map[string]bool) []string {
+	var keys []string
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,122 @@
+package wider
+
+import (
+	"fmt"
+	"io"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	resourcehelper "k8s.io/component-helpers/resource"
+)
+
+// displayedResources are the resources shown by the resources output, in column order.
+var displayedResources = []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory, corev1.ResourceEphemeralStorage}
+
+// PodResources are the effective requests and limits of a pod next to the
+// allocatable resources of its node, the value of the .resources root.
+type PodResources struct {
+	// Requests sum the container requests the way the scheduler does: the
+	// largest init container request wins over the sum of regular containers
+	// when higher, and pod overhead is added.
+	Requests corev1.ResourceList `json:"requests,omitempty"`
+	// Limits are summed with the same semantics as Requests.
+	Limits corev1.ResourceList `json:"limits,omitempty"`
+	// Allocatable is the node status.allocatable, empty when the pod has no node.
+	Allocatable corev1.ResourceList `json:"allocatable,omitempty"`
+	// RequestsPercent and LimitsPercent are the share of the node allocatable
+	// used by the pod, per resource.
+	RequestsPercent map[corev1.ResourceName]int64 `json:"requestsPercent,omitempty"`
+	LimitsPercent   map[corev1.ResourceName]int64 `json:"limitsPercent,omitempty"`
+}
+
+// ComputePodResources returns the resources of an enriched pod.
+func ComputePodResources(pn PodWithWider) PodResources {
+	r := PodResources{
+		Requests: resourcehelper.PodRequests(pn.Pod, resourcehelper.PodResourcesOptions{}),
+		Limits:   resourcehelper.PodLimits(pn.Pod, resourcehelper.PodResourcesOptions{}),
+	}
+	if pn.Node == nil || len(pn.Node.Status.Allocatable) == 0 {
+		return r
+	}
+
+	r.Allocatable = pn.Node.Status.Allocatable
+	r.RequestsPercent = percentOf(r.Requests, r.Allocatable)
+	r.LimitsPercent = percentOf(r.Limits, r.Allocatable)
+	return r
+}
+
+// percentOf returns the percentage of allocatable used by every resource of used.
+func percentOf(used, allocatable corev1.ResourceList) map[corev1.ResourceName]int64 {
+	percents := make(map[corev1.ResourceName]int64)
+	for name, q := range used {
+		total, ok := allocatable[name]
+		if !ok || total.IsZero() {
+			continue
+		}
+		percents[name] = q.MilliValue() * 100 / total.MilliValue()
+	}
+	return percents
+}
+
+// formatUsage renders a quantity with its share of the node like kubectl describe node does, e.g. 500m (12%).
+func formatUsage(used corev1.ResourceList, percents map[corev1.ResourceName]int64, name corev1.ResourceName) string {
+	q, ok := used[name]
+	if !ok {
+		q = *resource.NewQuantity(0, resource.DecimalSI)
+	}
+	if percents == nil {
+		return q.String()
+	}
+	return fmt.Sprintf("%s (%d%%)", q.String(), percents[name])
+}
+
+func newResourcesPrinter(out io.Writer, opts PrintOptions) Printer {
+	var headers []string
+	if opts.ShowCluster {
+		headers = append(headers, "CLUSTER")
+	}
+	if opts.AllNamespaces {
+		headers = append(headers, "NAMESPACE")
+	}
+	headers = append(headers, "NAME",
+		"CPU REQUESTS", "CPU LIMITS",
+		"MEMORY REQUESTS", "MEMORY LIMITS",
+		"EPHEMERAL REQUESTS", "EPHEMERAL LIMITS",
+		"NODE", "NODE CPU", "NODE MEMORY", "NODE EPHEMERAL")
+
+	return &tablePrinter{
+		out:     out,
+		headers: headers,
+		row: func(pn PodWithWider) []string {
+			return resourcesRow(opts, pn)
+		},
+	}
+}
+
+func resourcesRow(opts PrintOptions, pn PodWithWider) []string {
+	r := ComputePodResources(pn)
+
+	var values []string
+	if opts.ShowCluster {
+		values = append(values, pn.Cluster)
+	}
+	if opts.AllNamespaces {
+		values = append(values, pn.Pod.Namespace)
+	}
+	values = append(values, pn.Pod.Name)
+	for _, name := range displayedResources {
+		values = append(values,
+			formatUsage(r.Requests, r.RequestsPercent, name),
+			formatUsage(r.Limits, r.LimitsPercent, name))
+	}
+	values = append(values, pn.Pod.Spec.NodeName)
+	for _, name := range displayedResources {
+		q, ok := r.Allocatable[name]
+		if !ok {
+			values = append(values, "<none>")
+			continue
+		}
+		values = append(values, q.String())
+	}
+	return values
+}
@@ -0,0 +1,156 @@
+package wider
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Topology flags raised by TopologySpread.
+const (
+	// SpreadSingleZone is raised when every scheduled replica of a workload runs in the same zone.
+	SpreadSingleZone = "single-zone"
+	// SpreadSingleNode is raised when every scheduled replica of a workload runs on the same node.
+	SpreadSingleNode = "single-node"
+)
+
+// WorkloadSpread is how the pods of a workload are distributed across the
+// topology domains of their nodes.
+type WorkloadSpread struct {
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace"`
+	// Kind and Name identify the workload owning the pods. Pods of a
+	// ReplicaSet created by a Deployment are grouped under the Deployment,
+	// and pods without a controller are their own workload.
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	Pods int    `json:"pods"`
+	// Unscheduled counts the pods without a node, left out of the domains.
+	Unscheduled int `json:"unscheduled,omitempty"`
+	// Zones, Regions and Nodes count the pods per topology domain, from the
+	// topology.kubernetes.io/zone and region labels and the node name.
+	// Scheduled pods whose node has no label are counted under <none>.
+	Zones   map[string]int `json:"zones,omitempty"`
+	Regions map[string]int `json:"regions,omitempty"`
+	Nodes   map[string]int `json:"nodes,omitempty"`
+	// Flags are SpreadSingleZone and SpreadSingleNode when they apply to a
+	// workload with more than one scheduled pod.
+	Flags []string `json:"flags,omitempty"`
+}
+
+// TopologySpread groups pods by workload and counts them per zone, region
+// and node, sorted by cluster, namespace, kind and name.
+func TopologySpread(podNodes []PodWithWider) []WorkloadSpread {
+	workloads := make(map[string]*WorkloadSpread)
+	var keys []string
+
+	for _, pn := range podNodes {
+		kind, name := podWorkload(pn.Pod)
+		key := strings.Join([]string{pn.Cluster, pn.Pod.Namespace, kind, name}, "/")
+		w := workloads[key]
+		if w == nil {
+			w = &WorkloadSpread{
+				Cluster:   pn.Cluster,
+				Namespace: pn.Pod.Namespace,
+				Kind:      kind,
+				Name:      name,
+				Zones:     make(map[string]int),
+				Regions:   make(map[string]int),
+				Nodes:     make(map[string]int),
+			}
+			workloads[key] = w
+			keys = append(keys, key)
+		}
+
+		w.Pods++
+		if pn.Pod.Spec.NodeName == "" {
+			w.Unscheduled++
+			continue
+		}
+		var nodeLabels map[string]string
+		if pn.Node != nil {
+			nodeLabels = pn.Node.Labels
+		}
+		w.Zones[noneIfEmpty(nodeLabels[corev1.LabelTopologyZone])]++
+		w.Regions[noneIfEmpty(nodeLabels[corev1.LabelTopologyRegion])]++
+		w.Nodes[pn.Pod.Spec.NodeName]++
+	}
+
+	sort.Strings(keys)
+	spreads := make([]WorkloadSpread, 0, len(keys))
+	for _, key := range keys {
+		w := workloads[key]
+		if scheduled := w.Pods - w.Unscheduled; scheduled > 1 {
+			if _, unknown := w.Zones["<none>"]; len(w.Zones) == 1 && !unknown {
+				w.Flags = append(w.Flags, SpreadSingleZone)
+			}
+			if len(w.Nodes) == 1 {
+				w.Flags = append(w.Flags, SpreadSingleNode)
+			}
+		}
+		spreads = append(spreads, *w)
+	}
+	return spreads
+}
+
+// podWorkload returns the kind and name of the workload a pod belongs to.
+func podWorkload(pod *corev1.Pod) (string, string) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return "Pod", pod.Name
+	}
+	return controllerWorkload(pod, owner)
+}
+
+func printTopologySpread(out io.Writer, opts PrintOptions, spreads []WorkloadSpread) error {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+
+	var headers []string
+	if opts.ShowCluster {
+		headers = append(headers, "CLUSTER")
+	}
+	headers = append(headers, "NAMESPACE", "WORKLOAD", "PODS", "ZONES", "REGIONS", "NODES", "FLAGS")
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+
+	for _, s := range spreads {
+		var values []string
+		if opts.ShowCluster {
+			values = append(values, s.Cluster)
+		}
+		pods := fmt.Sprintf("%d", s.Pods)
+		if s.Unscheduled > 0 {
+			pods = fmt.Sprintf("%d (%d unscheduled)", s.Pods, s.Unscheduled)
+		}
+		values = append(values,
+			s.Namespace,
+			s.Kind+"/"+s.Name,
+			pods,
+			formatCounts(s.Zones),
+			formatCounts(s.Regions),
+			fmt.Sprintf("%d", len(s.Nodes)),
+			noneIfEmpty(strings.Join(s.Flags, ",")))
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+
+	return w.Flush()
+}
+
+// formatCounts renders pod counts per domain sorted by domain, e.g. eu-west-1a=2,eu-west-1b=1.
+func formatCounts(counts map[string]int) string {
+	var domains []string
+	for domain := range counts {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	var parts []string
+	for _, domain := range domains {
+		parts = append(parts, fmt.Sprintf("%s=%d", domain, counts[domain]))
+	}
+	return noneIfEmpty(strings.Join(parts, ","))
+}
@@ -0,0 +1,369 @@
+package wider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+
+	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PodWithWider is a pod joined with the resources it is attached to. JSON
+// fields are named after the custom-columns roots, including the values of
+// custom relations which are written as fields named after the relation.
+type PodWithWider struct {
+	Cluster        string                          `json:"cluster,omitempty"`
+	Pod            *corev1.Pod                     `json:"pod"`
+	Node           *corev1.Node                    `json:"node"`
+	ServiceAccount *corev1.ServiceAccount          `json:"serviceAccount"`
+	PVCs           []*corev1.PersistentVolumeClaim `json:"pvcs"`
+	// PVs are the volumes bound to PVCs, in the same order without unbound claims.
+	PVs []*corev1.PersistentVolume `json:"pvs"`
+	// Container is set on the rows of a single container, see ExpandContainers.
+	Container *ContainerWithStatus `json:"container,omitempty"`
+	// Related holds the values of relations registered outside this package, keyed by
+	// relation name. They are written as top-level JSON fields, see MarshalJSON.
+	Related map[string]interface{} `json:"-"`
+	// Forbidden names the relations RBAC did not allow loading.
+	Forbidden []string `json:"forbidden,omitempty"`
+}
+
+// podWithWiderFields is PodWithWider without its JSON methods.
+type podWithWiderFields PodWithWider
+
+// MarshalJSON writes the pod with the values of custom relations added after
+// the built-in fields, sorted by relation name.
+func (pn PodWithWider) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(podWithWiderFields(pn))
+	if err != nil || len(pn.Related) == 0 {
+		return data, err
+	}
+
+	names := make([]string, 0, len(pn.Related))
+	for name := range pn.Related {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	b.Write(data[:len(data)-1])
+	for _, name := range names {
+		if isPodWithWiderField(name) {
+			continue
+		}
+		key, _ := json.Marshal(name)
+		value, err := json.Marshal(pn.Related[name])
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal %s: %w", name, err)
+		}
+		b.WriteByte(',')
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// UnmarshalJSON reads a pod written by MarshalJSON, fields other than the
+// built-in ones are stored in Related.
+func (pn *PodWithWider) UnmarshalJSON(data []byte) error {
+	var fields podWithWiderFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	*pn = PodWithWider(fields)
+	for name, raw := range all {
+		if isPodWithWiderField(name) {
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return fmt.Errorf("failed to unmarshal %s: %w", name, err)
+		}
+		pn.SetRelated(name, value)
+	}
+	return nil
+}
+
+// isPodWithWiderField reports whether name is the JSON name of a built-in PodWithWider field.
+func isPodWithWiderField(name string) bool {
+	return name != "" && findFieldByJSONTag(reflect.ValueOf(PodWithWider{}), name).IsValid()
+}
+
+// APIVersion and ListKind identify the documents written by the json and yaml outputs.
+const (
+	APIVersion = "wider.io/v1alpha1"
+	ListKind   = "PodWithWiderList"
+)
+
+// PodWithWiderList is the document written by the json and yaml outputs,
+// shaped like a Kubernetes List so it can be read back by other tools.
+type PodWithWiderList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []PodWithWider `json:"items"`
+}
+
+// NewPodWithWiderList wraps enriched pods in a versioned list.
+func NewPodWithWiderList(podNodes []PodWithWider) *PodWithWiderList {
+	if podNodes == nil {
+		podNodes = []PodWithWider{}
+	}
+	return &PodWithWiderList{
+		TypeMeta: metav1.TypeMeta{APIVersion: APIVersion, Kind: ListKind},
+		Items:    podNodes,
+	}
+}
+
+// Options selects the pods to enrich and the relations to join.
+type Options struct {
+	// Cluster is recorded on every result, e.g. the kubeconfig context name.
+	Cluster string
+	// Namespace limits the query, empty means all namespaces.
+	Namespace     string
+	LabelSelector string
+	// Relations names the relations to load, see Registry.RelationsForOutput.
+	Relations []string
+	// MetadataOnly names the relations of which only the object metadata is
+	// read, see Registry.MetadataOnlyForOutput.
+	MetadataOnly []string
+	// Registry resolves relation names, DefaultRegistry when nil.
+	Registry *Registry
+	// Concurrency bounds the API calls made in parallel, DefaultConcurrency when zero.
+	Concurrency int
+	// ChunkSize is the page size for list calls, zero lists everything at once.
+	ChunkSize int64
+	// GetThreshold is the number of related objects fetched one by one before
+	// listing them all, DefaultGetThreshold when zero and always list when negative.
+	GetThreshold int
+	// Cache keeps the node and PersistentVolume lists between runs, nil disables it.
+	Cache *Cache
+	// Strict fails the run when a relation is forbidden instead of leaving it
+	// out and marking the pods with PodWithWider.Forbidden, or when the
+	// cluster does not serve the resource of a relation instead of leaving it out.
+	Strict bool
+	// Warn is called once for every relation left out of the results.
+	Warn func(message string)
+}
+
+// DefaultChunkSize matches the kubectl --chunk-size default.
+const DefaultChunkSize = 500
+
+// DefaultConcurrency is the number of parallel API calls made when Options.Concurrency is unset.
+const DefaultConcurrency = 4
+
+func concurrencyLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultConcurrency
+	}
+	return limit
+}
+
+// Enricher lists pods in a cluster and joins them with their related resources.
+type Enricher struct {
+	clients Clients
+	opts    Options
+}
+
+// NewEnricher returns an Enricher querying clients.
+func NewEnricher(clients Clients, opts Options) *Enricher {
+	if opts.Registry == nil {
+		opts.Registry = DefaultRegistry
+	}
+	return &Enricher{
+		clients: clients,
+		opts:    opts,
+	}
+}
+
+// Enrich lists pods and joins them with the requested relations.
+func (e *Enricher) Enrich(ctx context.Context) ([]PodWithWider, error) {
+	var podNodes []PodWithWider
+	err := e.Stream(ctx, func(page []PodWithWider) error {
+		podNodes = append(podNodes, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return podNodes, nil
+}
+
+// Stream lists pods page by page, joins each page with the requested
+// relations and hands it to fn before fetching the next one.
+func (e *Enricher) Stream(ctx context.Context, fn func(page []PodWithWider) error) error {
+	relations, err := e.opts.Registry.resolve(e.opts.Relations)
+	if err != nil {
+		return err
+	}
+
+	req := e.loadRequest()
+	loaders := make([]*relationLoader, len(relations))
+	for i, rel := range relations {
+		relReq := req
+		relReq.MetadataOnly = e.clients.Metadata != nil && slices.Contains(e.opts.MetadataOnly, rel.Name())
+		loaders[i] = &relationLoader{name: rel.Name(), loader: rel.NewLoader(relReq)}
+	}
+
+	// Get pods
+	err = listPages(ctx, e.opts.ChunkSize, func(opts metav1.ListOptions) (string, error) {
+		opts.LabelSelector = e.opts.LabelSelector
+		pods, err := e.clients.Kubernetes.CoreV1().Pods(e.opts.Namespace).List(ctx, opts)
+		if err != nil {
+			return "", fmt.Errorf("failed to list pods: %w", err)
+		}
+
+		page, err := e.joinPage(ctx, loaders, pods.Items)
+		if err != nil {
+			return "", err
+		}
+		if err := fn(page); err != nil {
+			return "", err
+		}
+		return pods.Continue, nil
+	})
+	return err
+}
+
+// loadRequest returns the request relations are loaded with during one run.
+func (e *Enricher) loadRequest() LoadRequest {
+	return LoadRequest{
+		Clients:      e.clients,
+		Namespace:    e.opts.Namespace,
+		Concurrency:  e.opts.Concurrency,
+		ChunkSize:    e.opts.ChunkSize,
+		GetThreshold: e.opts.GetThreshold,
+		Cache:        e.opts.Cache,
+		calls:        newCallLimiter(e.opts.Concurrency),
+		claims:       &sharedClaims{},
+	}
+}
+
+// warn reports a result left out of the run.
+func (e *Enricher) warn(message string) {
+	if e.opts.Warn != nil {
+		e.opts.Warn(message)
+	}
+}
+
+// relationLoader tracks the loader of a relation during one run.
+type relationLoader struct {
+	name   string
+	loader Loader
+	// forbidden is set once RBAC refused the relation, it is not loaded again.
+	forbidden bool
+	// unavailable is set once the cluster turned out not to serve the
+	// resource of the relation, e.g. a custom resource without its CRD.
+	unavailable bool
+}
+
+// joinPage loads the relations for a page of pods concurrently and joins them.
+// The API calls of the loaders are bounded together by their call limiter.
+func (e *Enricher) joinPage(ctx context.Context, loaders []*relationLoader, pods []corev1.Pod) ([]PodWithWider, error) {
+	joins := make([]JoinFunc, len(loaders))
+	skipped := make([]error, len(loaders))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, l := range loaders {
+		if l.forbidden || l.unavailable {
+			continue
+		}
+		g.Go(func() error {
+			join, err := l.loader.Load(gctx, pods)
+			if err != nil && !e.opts.Strict && (apierrors.IsForbidden(err) || isNotServed(err)) {
+				skipped[i] = err
+				return nil
+			}
+			joins[i] = join
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	for i, err := range skipped {
+		if err == nil {
+			continue
+		}
+		var message string
+		if apierrors.IsForbidden(err) {
+			loaders[i].forbidden = true
+			message = fmt.Sprintf("%s is forbidden and shown as <forbidden>: %v", loaders[i].name, err)
+		} else {
+			loaders[i].unavailable = true
+			message = fmt.Sprintf("%s is not served by the cluster and shown as <none>: %v", loaders[i].name, err)
+		}
+		e.warn(message)
+	}
+
+	// Build pod with related information
+	var podNodes []PodWithWider
+	for i := range pods {
+		pn := PodWithWider{
+			Cluster: e.opts.Cluster,
+			Pod:     &pods[i],
+		}
+		for j, join := range joins {
+			if loaders[j].forbidden {
+				pn.Forbidden = append(pn.Forbidden, loaders[j].name)
+				continue
+			}
+			if loaders[j].unavailable {
+				continue
+			}
+			join(&pn)
+		}
+		podNodes = append(podNodes, pn)
+	}
+	return podNodes, nil
+}
+
+// isNotServed reports whether err means the resource of a relation does not
+// exist in the cluster, such as a custom resource whose CRD is not installed.
+func isNotServed(err error) bool {
+	return apierrors.IsNotFound(err) || meta.IsNoMatchError(err)
+}
+
+// listPages calls list with Limit and Continue set until the collection is
+// exhausted. list returns the continue token of the page it fetched.
+func listPages(ctx context.Context, chunkSize int64, list func(opts metav1.ListOptions) (string, error)) error {
+	opts := metav1.ListOptions{Limit: chunkSize}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		next, err := list(opts)
+		if err != nil {
+			return err
+		}
+		if next == "" {
+			return nil
+		}
+		opts.Continue = next
+	}
+}
+
+// IsForbidden reports whether RBAC did not allow loading the named relation for the pod.
+func (pn PodWithWider) IsForbidden(relation string) bool {
+	return slices.Contains(pn.Forbidden, relation)
+}
+
+// SetRelated stores the value of a custom relation on the pod.
+func (pn *PodWithWider) SetRelated(name string, value interface{}) {
+	if pn.Related == nil {
+		pn.Related = make(map[string]interface{})
+	}
+	pn.Related[name] = value
+}
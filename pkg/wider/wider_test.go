@@ -0,0 +1,2226 @@
+package wider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestFormatAge(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration time.Duration
+		expected string
+	}{
+		{
+			name:     "30 seconds",
+			duration: 30 * time.Second,
+			expected: "30s",
+		},
+		{
+			name:     "5 minutes",
+			duration: 5 * time.Minute,
+			expected: "5m",
+		},
+		{
+			name:     "2 hours",
+			duration: 2 * time.Hour,
+			expected: "2h",
+		},
+		{
+			name:     "29 hours",
+			duration: 29 * time.Hour,
+			expected: "29h",
+		},
+		{
+			name:     "47 hours",
+			duration: 47 * time.Hour,
+			expected: "47h",
+		},
+		{
+			name:     "2 days",
+			duration: 48 * time.Hour,
+			expected: "2d",
+		},
+		{
+			name:     "5 days",
+			duration: 5 * 24 * time.Hour,
+			expected: "5d",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creationTime := metav1.NewTime(time.Now().Add(-tt.duration))
+			result := FormatAge(creationTime)
+			if result != tt.expected {
+				t.Errorf("FormatAge() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCapitalizeFirst(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"metadata", "Metadata"},
+		{"name", "Name"},
+		{"status", "Status"},
+		{"", ""},
+		{"a", "A"},
+		{"ABC", "ABC"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result := capitalizeFirst(tt.input)
+			if result != tt.expected {
+				t.Errorf("capitalizeFirst(%q) = %v, want %v", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSplitPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected []string
+	}{
+		{
+			name:     "simple path",
+			path:     "pod.metadata.name",
+			expected: []string{"pod", "metadata", "name"},
+		},
+		{
+			name:     "path with escaped dot",
+			path:     "node.metadata.labels.kubernetes\\.io/os",
+			expected: []string{"node", "metadata", "labels", "kubernetes.io/os"},
+		},
+		{
+			name:     "multiple escaped dots",
+			path:     "a\\.b.c\\.d.e",
+			expected: []string{"a.b", "c.d", "e"},
+		},
+		{
+			name:     "leading dot",
+			path:     ".pod.metadata.name",
+			expected: []string{"pod", "metadata", "name"},
+		},
+		{
+			name:     "trailing dot",
+			path:     "pod.metadata.",
+			expected: []string{"pod", "metadata"},
+		},
+		{
+			name:     "empty string",
+			path:     "",
+			expected: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := splitPath(tt.path)
+			if len(result) != len(tt.expected) {
+				t.Errorf("splitPath(%q) length = %v, want %v", tt.path, len(result), len(tt.expected))
+				return
+			}
+			for i := range result {
+				if result[i] != tt.expected[i] {
+					t.Errorf("splitPath(%q)[%d] = %v, want %v", tt.path, i, result[i], tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGetValueByPath(t *testing.T) {
+	// Create test pod
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "default",
+			Labels: map[string]string{
+				"app": "myapp",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "ReplicaSet",
+					Name: "test-rs",
+				},
+			},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:           "node1",
+			ServiceAccountName: "default",
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+		},
+	}
+
+	// Create test node
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node1",
+			Labels: map[string]string{
+				"kubernetes.io/os": "linux",
+			},
+		},
+		Status: corev1.NodeStatus{
+			NodeInfo: corev1.NodeSystemInfo{
+				OperatingSystem: "linux",
+			},
+		},
+	}
+
+	// Create test ServiceAccount
+	sa := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "default",
+			Namespace: "default",
+		},
+	}
+
+	// Create test PVC
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-pvc",
+			Namespace: "default",
+		},
+	}
+
+	pn := PodWithWider{
+		Cluster:        "prod",
+		Pod:            pod,
+		Node:           node,
+		ServiceAccount: sa,
+		PVCs:           []*corev1.PersistentVolumeClaim{pvc},
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "pod name",
+			path:     ".pod.metadata.name",
+			expected: "test-pod",
+			wantErr:  false,
+		},
+		{
+			name:     "pod namespace",
+			path:     "pod.metadata.namespace",
+			expected: "default",
+			wantErr:  false,
+		},
+		{
+			name:     "pod label",
+			path:     "pod.metadata.labels.app",
+			expected: "myapp",
+			wantErr:  false,
+		},
+		{
+			name:     "node name",
+			path:     ".node.metadata.name",
+			expected: "node1",
+			wantErr:  false,
+		},
+		{
+			name:     "node label with escaped dot",
+			path:     ".node.metadata.labels.kubernetes\\.io/os",
+			expected: "linux",
+			wantErr:  false,
+		},
+		{
+			name:     "node OS",
+			path:     ".node.status.nodeInfo.operatingSystem",
+			expected: "linux",
+			wantErr:  false,
+		},
+		{
+			name:     "service account name",
+			path:     ".serviceAccount.metadata.name",
+			expected: "default",
+			wantErr:  false,
+		},
+		{
+			name:     "service account via sa alias",
+			path:     ".sa.metadata.name",
+			expected: "default",
+			wantErr:  false,
+		},
+		{
+			name:     "pvcs list",
+			path:     ".pvcs",
+			expected: "test-pvc",
+			wantErr:  false,
+		},
+		{
+			name:     "cluster name",
+			path:     ".cluster",
+			expected: "prod",
+			wantErr:  false,
+		},
+		{
+			name:     "pod status phase",
+			path:     ".pod.status.phase",
+			expected: "Running",
+			wantErr:  false,
+		},
+		{
+			name:     "invalid path start",
+			path:     ".invalid.metadata.name",
+			expected: "",
+			wantErr:  true,
+		},
+		{
+			name:     "nonexistent field",
+			path:     ".pod.metadata.nonexistent",
+			expected: "",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := GetValueByPath(pn, tt.path)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("GetValueByPath(%q) expected error but got none", tt.path)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("GetValueByPath(%q) unexpected error: %v", tt.path, err)
+				return
+			}
+			if result != tt.expected {
+				t.Errorf("GetValueByPath(%q) = %v, want %v", tt.path, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetValueByPath_NilNode(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-pod",
+		},
+	}
+
+	pn := PodWithWider{
+		Pod:  pod,
+		Node: nil, // Node not assigned yet
+	}
+
+	result, err := GetValueByPath(pn, ".node.metadata.name")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if result != "<none>" {
+		t.Errorf("expected <none> for nil node, got %v", result)
+	}
+}
+
+func TestGetValueByPath_NilServiceAccount(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-pod",
+		},
+	}
+
+	pn := PodWithWider{
+		Pod:            pod,
+		ServiceAccount: nil,
+	}
+
+	result, err := GetValueByPath(pn, ".sa.metadata.name")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if result != "<none>" {
+		t.Errorf("expected <none> for nil service account, got %v", result)
+	}
+}
+
+func TestGetValueByPath_EmptyPVCs(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-pod",
+		},
+	}
+
+	pn := PodWithWider{
+		Pod:  pod,
+		PVCs: nil,
+	}
+
+	result, err := GetValueByPath(pn, ".pvcs")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if result != "<none>" {
+		t.Errorf("expected <none> for empty PVCs, got %v", result)
+	}
+}
+
+func TestFindFieldByJSONTag(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-pod",
+		},
+	}
+
+	val := findFieldByJSONTag(reflect.ValueOf(*pod), "metadata")
+	if !val.IsValid() {
+		t.Error("expected to find 'metadata' field by JSON tag")
+	}
+
+	val = findFieldByJSONTag(reflect.ValueOf(*pod), "nonexistent")
+	if val.IsValid() {
+		t.Error("expected not to find 'nonexistent' field")
+	}
+}
+
+func TestRelationsForOutput(t *testing.T) {
+	tests := []struct {
+		outputFormat string
+		expected     []string
+	}{
+		{"", []string{"node"}},
+		{"json", []string{"node", "serviceAccount", "pvcs", "pvs"}},
+		{"yaml", []string{"node", "serviceAccount", "pvcs", "pvs"}},
+		{"jsonl", []string{"node", "serviceAccount", "pvcs", "pvs"}},
+		{"custom-columns=NAME:.pod.metadata.name", nil},
+		{"custom-columns=SA:.sa.metadata.name", []string{"serviceAccount"}},
+		{"custom-columns=NODE:.node.metadata.name,PVCS:.pvcs", []string{"node", "pvcs"}},
+		{"custom-columns=SA:.pod.spec.serviceAccountName,CLAIM:.pod.metadata.labels.pvc", nil},
+		{"custom-columns=SA:.sa", []string{"serviceAccount"}},
+		{"csv=SA:.sa.metadata.name", []string{"serviceAccount"}},
+		{"tsv=NODE:.node.metadata.name", []string{"node"}},
+		{"custom-columns=NAME", nil},
+		{"wide", []string{"node"}},
+		{"markdown", []string{"node"}},
+		{"html=SA:.sa.metadata.name", []string{"serviceAccount"}},
+		{"name", nil},
+		{"resources", []string{"node"}},
+		{"custom-columns=CPU:.resources.requests.cpu", []string{"node"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.outputFormat, func(t *testing.T) {
+			result := DefaultRegistry.RelationsForOutput(tt.outputFormat)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("RelationsForOutput(%q) = %v, want %v", tt.outputFormat, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRelationsFor(t *testing.T) {
+	tests := []struct {
+		outputFormat string
+		include      []string
+		expected     []string
+		wantErr      bool
+	}{
+		{"json", nil, []string{"node", "serviceAccount", "pvcs", "pvs"}, false},
+		{"json", []string{"node"}, []string{"node"}, false},
+		{"yaml", []string{"pvc", "sa"}, []string{"serviceAccount", "pvcs"}, false},
+		{"", []string{"pvcs"}, []string{"node", "pvcs"}, false},
+		{"custom-columns=SA:.sa.metadata.name", []string{"node"}, []string{"node", "serviceAccount"}, false},
+		{"json", []string{"nodes"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.outputFormat, func(t *testing.T) {
+			result, err := DefaultRegistry.RelationsFor(tt.outputFormat, tt.include)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("RelationsFor(%q, %v) expected error but got none", tt.outputFormat, tt.include)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RelationsFor(%q, %v) unexpected error: %v", tt.outputFormat, tt.include, err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("RelationsFor(%q, %v) = %v, want %v", tt.outputFormat, tt.include, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected Path
+		wantErr  bool
+	}{
+		{".pod.metadata.name", Path{Root: "pod", Fields: []string{"metadata", "name"}}, false},
+		{"node.metadata.labels.kubernetes\\.io/os", Path{Root: "node", Fields: []string{"metadata", "labels", "kubernetes.io/os"}}, false},
+		{".pvcs", Path{Root: "pvcs", Fields: []string{}}, false},
+		{".", Path{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			result, err := ParsePath(tt.path)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParsePath(%q) expected error but got none", tt.path)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePath(%q) unexpected error: %v", tt.path, err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("ParsePath(%q) = %#v, want %#v", tt.path, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMetadataOnlyForOutput(t *testing.T) {
+	tests := []struct {
+		outputFormat string
+		expected     []string
+	}{
+		{"", nil},
+		{"json", nil},
+		{"custom-columns=NODE:.node.metadata.name,SA:.sa.metadata.labels.team", []string{"node", "serviceAccount"}},
+		{"custom-columns=NODE:.node.metadata.name,IMAGES:.node.status.images", nil},
+		{"custom-columns=NODE:.node", nil},
+		{"custom-columns=NODE:.node.metadata.name,SA:.serviceAccount.secrets", []string{"node"}},
+		{"custom-columns=NAME:.pod.metadata.name", nil},
+		{"custom-columns=NODE:.node.metadata.name,CPU:.resources.requestsPercent.cpu", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.outputFormat, func(t *testing.T) {
+			result := DefaultRegistry.MetadataOnlyForOutput(tt.outputFormat)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("MetadataOnlyForOutput(%q) = %v, want %v", tt.outputFormat, result, tt.expected)
+			}
+		})
+	}
+}
+
+// ownerRelation is a custom relation exposing the pod's controller reference.
+type ownerRelation struct{}
+
+func (ownerRelation) Name() string    { return "owner" }
+func (ownerRelation) Roots() []string { return []string{"owner"} }
+
+func (ownerRelation) NewLoader(req LoadRequest) Loader {
+	return LoaderFunc(func(ctx context.Context, pods []corev1.Pod) (JoinFunc, error) {
+		return func(pn *PodWithWider) {
+			if ref := metav1.GetControllerOf(pn.Pod); ref != nil {
+				pn.SetRelated("owner", ref)
+			}
+		}, nil
+	})
+}
+
+func (ownerRelation) Value(pn PodWithWider) (interface{}, bool) {
+	value, ok := pn.Related["owner"]
+	return value, ok
+}
+
+func TestRegistryCustomRelation(t *testing.T) {
+	registry := NewRegistry(nodeRelation{}, ownerRelation{})
+	controller := true
+	client := fake.NewClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-1",
+			Namespace: "default",
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "ReplicaSet", Name: "web-abc", Controller: &controller},
+			},
+		},
+	})
+
+	relations := registry.RelationsForOutput("custom-columns=OWNER:.owner.name")
+	if !reflect.DeepEqual(relations, []string{"owner"}) {
+		t.Fatalf("expected only the owner relation to be loaded, got %v", relations)
+	}
+
+	pods, err := NewEnricher(Clients{Kubernetes: client}, Options{
+		Namespace: "default",
+		Relations: relations,
+		Registry:  registry,
+	}).Enrich(context.Background())
+	if err != nil {
+		t.Fatalf("Enrich() unexpected error: %v", err)
+	}
+
+	result, err := registry.GetValueByPath(pods[0], ".owner.name")
+	if err != nil {
+		t.Fatalf("GetValueByPath() unexpected error: %v", err)
+	}
+	if result != "web-abc" {
+		t.Errorf("expected owner web-abc, got %q", result)
+	}
+
+	if _, err := NewEnricher(Clients{Kubernetes: client}, Options{Relations: []string{"missing"}, Registry: registry}).Enrich(context.Background()); err == nil {
+		t.Error("expected error for unknown relation")
+	}
+}
+
+func TestEnricherEnrich(t *testing.T) {
+	client := fake.NewClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "default"}},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "default"}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"},
+			Spec: corev1.PodSpec{
+				NodeName:           "node1",
+				ServiceAccountName: "default",
+				Volumes: []corev1.Volume{{
+					Name: "data",
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"},
+					},
+				}},
+			},
+		},
+	)
+
+	pods, err := NewEnricher(Clients{Kubernetes: client}, Options{
+		Cluster:   "prod",
+		Namespace: "default",
+		Relations: []string{"node", "serviceAccount", "pvcs"},
+	}).Enrich(context.Background())
+	if err != nil {
+		t.Fatalf("Enrich() unexpected error: %v", err)
+	}
+	if len(pods) != 1 {
+		t.Fatalf("expected 1 pod, got %d", len(pods))
+	}
+
+	pn := pods[0]
+	if pn.Cluster != "prod" {
+		t.Errorf("expected cluster prod, got %q", pn.Cluster)
+	}
+	if pn.Node == nil || pn.Node.Name != "node1" {
+		t.Errorf("expected node1, got %v", pn.Node)
+	}
+	if pn.ServiceAccount == nil || pn.ServiceAccount.Name != "default" {
+		t.Errorf("expected service account default, got %v", pn.ServiceAccount)
+	}
+	if len(pn.PVCs) != 1 || pn.PVCs[0].Name != "data" {
+		t.Errorf("expected PVC data, got %v", pn.PVCs)
+	}
+}
+
+func TestLoadRelationsConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wider.yaml")
+	config := `relations:
+  - name: vpa
+    group: autoscaling.k8s.io
+    version: v1
+    resource: verticalpodautoscalers
+    match:
+      field: spec.targetRef.name
+      pod: workload
+  - name: certificate
+    roots: [cert, certificate]
+    group: cert-manager.io
+    version: v1
+    resource: certificates
+    match:
+      field: spec.secretName
+      pod: secrets
+`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	relations, err := LoadRelationsConfig(path)
+	if err != nil {
+		t.Fatalf("LoadRelationsConfig() unexpected error: %v", err)
+	}
+	if len(relations) != 2 {
+		t.Fatalf("expected 2 relations, got %d", len(relations))
+	}
+	if !reflect.DeepEqual(relations[0].Roots(), []string{"vpa"}) {
+		t.Errorf("expected default root vpa, got %v", relations[0].Roots())
+	}
+	if !reflect.DeepEqual(relations[1].Roots(), []string{"cert", "certificate"}) {
+		t.Errorf("expected roots cert and certificate, got %v", relations[1].Roots())
+	}
+}
+
+func TestNewCustomRelation_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		def  CustomRelation
+	}{
+		{"missing name", CustomRelation{Version: "v1", Resource: "things", Match: CustomMatch{Field: "spec.name", Pod: "name"}}},
+		{"missing resource", CustomRelation{Name: "thing", Version: "v1", Match: CustomMatch{Field: "spec.name", Pod: "name"}}},
+		{"missing field", CustomRelation{Name: "thing", Version: "v1", Resource: "things", Match: CustomMatch{Pod: "name"}}},
+		{"unknown pod key", CustomRelation{Name: "thing", Version: "v1", Resource: "things", Match: CustomMatch{Field: "spec.name", Pod: "image"}}},
+		{"reserved root", CustomRelation{Name: "pod", Version: "v1", Resource: "things", Match: CustomMatch{Field: "spec.name", Pod: "name"}}},
+		{"reserved name", CustomRelation{Name: "forbidden", Roots: []string{"thing"}, Version: "v1", Resource: "things", Match: CustomMatch{Field: "spec.name", Pod: "name"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewCustomRelation(tt.def); err == nil {
+				t.Error("expected error but got none")
+			}
+		})
+	}
+}
+
+func TestCustomRelation(t *testing.T) {
+	vpaGVR := schema.GroupVersionResource{Group: "autoscaling.k8s.io", Version: "v1", Resource: "verticalpodautoscalers"}
+	vpa := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "autoscaling.k8s.io/v1",
+		"kind":       "VerticalPodAutoscaler",
+		"metadata":   map[string]interface{}{"name": "web-vpa", "namespace": "default"},
+		"spec": map[string]interface{}{
+			"targetRef":    map[string]interface{}{"kind": "Deployment", "name": "web"},
+			"updatePolicy": map[string]interface{}{"updateMode": "Auto"},
+		},
+	}}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{vpaGVR: "VerticalPodAutoscalerList"}, vpa)
+
+	controller := true
+	client := fake.NewClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web-6cfd57b89f-4mcgm",
+				Namespace: "default",
+				Labels:    map[string]string{"pod-template-hash": "6cfd57b89f"},
+				OwnerReferences: []metav1.OwnerReference{
+					{Kind: "ReplicaSet", Name: "web-6cfd57b89f", Controller: &controller},
+				},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "standalone", Namespace: "default"},
+		},
+	)
+
+	rel, err := NewCustomRelation(CustomRelation{
+		Name:     "vpa",
+		Group:    vpaGVR.Group,
+		Version:  vpaGVR.Version,
+		Resource: vpaGVR.Resource,
+		Match:    CustomMatch{Field: "spec.targetRef.name", Pod: "workload"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := NewRegistry(nodeRelation{}, rel)
+
+	pods, err := NewEnricher(Clients{Kubernetes: client, Dynamic: dynamicClient}, Options{
+		Namespace: "default",
+		Relations: registry.RelationsForOutput("custom-columns=VPA:.vpa.metadata.name"),
+		Registry:  registry,
+	}).Enrich(context.Background())
+	if err != nil {
+		t.Fatalf("Enrich() unexpected error: %v", err)
+	}
+
+	byName := make(map[string]PodWithWider)
+	for _, pn := range pods {
+		byName[pn.Pod.Name] = pn
+	}
+
+	tests := []struct {
+		pod      string
+		path     string
+		expected string
+	}{
+		{"web-6cfd57b89f-4mcgm", ".vpa", "web-vpa"},
+		{"web-6cfd57b89f-4mcgm", ".vpa.metadata.name", "web-vpa"},
+		{"web-6cfd57b89f-4mcgm", ".vpa.spec.updatePolicy.updateMode", "Auto"},
+		{"standalone", ".vpa.metadata.name", "<none>"},
+	}
+	for _, tt := range tests {
+		result, err := registry.GetValueByPath(byName[tt.pod], tt.path)
+		if err != nil {
+			t.Errorf("GetValueByPath(%q) unexpected error: %v", tt.path, err)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("GetValueByPath(%q) = %v, want %v", tt.path, result, tt.expected)
+		}
+	}
+}
+
+func TestCustomRelation_ArgoRollout(t *testing.T) {
+	rolloutGVR := schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"}
+	rollout := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Rollout",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "default"},
+		"spec":       map[string]interface{}{"strategy": map[string]interface{}{"canary": map[string]interface{}{}}},
+	}}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{rolloutGVR: "RolloutList"}, rollout)
+
+	// Argo Rollouts label the pods of their ReplicaSets with their own hash label
+	controller := true
+	client := fake.NewClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-7d9f8c6b5-x2kq9",
+			Namespace: "default",
+			Labels:    map[string]string{"rollouts-pod-template-hash": "7d9f8c6b5"},
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "ReplicaSet", Name: "web-7d9f8c6b5", Controller: &controller},
+			},
+		},
+	})
+
+	rel, err := NewCustomRelation(CustomRelation{
+		Name:     "rollout",
+		Group:    rolloutGVR.Group,
+		Version:  rolloutGVR.Version,
+		Resource: rolloutGVR.Resource,
+		Match:    CustomMatch{Field: "metadata.name", Pod: "workload"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := NewRegistry(rel)
+
+	pods, err := NewEnricher(Clients{Kubernetes: client, Dynamic: dynamicClient}, Options{
+		Namespace: "default",
+		Relations: []string{"rollout"},
+		Registry:  registry,
+	}).Enrich(context.Background())
+	if err != nil {
+		t.Fatalf("Enrich() unexpected error: %v", err)
+	}
+	if result, _ := registry.GetValueByPath(pods[0], ".rollout.metadata.name"); result != "web" {
+		t.Errorf("GetValueByPath(.rollout.metadata.name) = %q, want web", result)
+	}
+}
+
+func TestCustomRelation_NotServed(t *testing.T) {
+	vpaGVR := schema.GroupVersionResource{Group: "autoscaling.k8s.io", Version: "v1", Resource: "verticalpodautoscalers"}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{vpaGVR: "VerticalPodAutoscalerList"})
+	// The CRD is not installed in this cluster
+	dynamicClient.PrependReactor("list", "verticalpodautoscalers", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewNotFound(vpaGVR.GroupResource(), "")
+	})
+	client := fake.NewClientset(
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default"}, Spec: corev1.PodSpec{NodeName: "node1"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+	)
+
+	rel, err := NewCustomRelation(CustomRelation{
+		Name:     "vpa",
+		Group:    vpaGVR.Group,
+		Version:  vpaGVR.Version,
+		Resource: vpaGVR.Resource,
+		Match:    CustomMatch{Field: "spec.targetRef.name", Pod: "workload"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := NewRegistry(nodeRelation{}, rel)
+
+	var warnings []string
+	opts := Options{
+		Namespace: "default",
+		Relations: registry.RelationsForOutput("json"),
+		Registry:  registry,
+		Warn: func(message string) {
+			warnings = append(warnings, message)
+		},
+	}
+	pods, err := NewEnricher(Clients{Kubernetes: client, Dynamic: dynamicClient}, opts).Enrich(context.Background())
+	if err != nil {
+		t.Fatalf("Enrich() unexpected error: %v", err)
+	}
+	if pods[0].Node == nil || pods[0].Related != nil || pods[0].IsForbidden("vpa") {
+		t.Errorf("expected node1 without a vpa, got %v and %v", pods[0].Node, pods[0].Related)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "vpa is not served") {
+		t.Errorf("expected a single warning about vpa, got %v", warnings)
+	}
+
+	opts.Strict = true
+	if _, err := NewEnricher(Clients{Kubernetes: client, Dynamic: dynamicClient}, opts).Enrich(context.Background()); !apierrors.IsNotFound(err) {
+		t.Errorf("Enrich() with Strict error = %v, want not found", err)
+	}
+}
+
+func TestEnricherEnrich_DeduplicatesFallbackGets(t *testing.T) {
+	var objects []runtime.Object
+	objects = append(objects, &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}})
+	for _, name := range []string{"web-1", "web-2", "web-3"} {
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       corev1.PodSpec{ServiceAccountName: "web"},
+		})
+	}
+	client := fake.NewClientset(objects...)
+
+	// Hide the ServiceAccount from the list so it has to be fetched directly
+	client.PrependReactor("list", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &corev1.ServiceAccountList{}, nil
+	})
+	var gets atomic.Int32
+	client.PrependReactor("get", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets.Add(1)
+		return false, nil, nil
+	})
+
+	pods, err := NewEnricher(Clients{Kubernetes: client}, Options{
+		Namespace:   "default",
+		Relations:   []string{"serviceAccount"},
+		Concurrency: 2,
+	}).Enrich(context.Background())
+	if err != nil {
+		t.Fatalf("Enrich() unexpected error: %v", err)
+	}
+
+	if gets.Load() != 1 {
+		t.Errorf("expected a single ServiceAccount get, got %d", gets.Load())
+	}
+	for _, pn := range pods {
+		if pn.ServiceAccount == nil || pn.ServiceAccount.Name != "web" {
+			t.Errorf("expected %s to be joined with service account web, got %v", pn.Pod.Name, pn.ServiceAccount)
+		}
+	}
+}
+
+func TestGetMissing_SharesCallLimit(t *testing.T) {
+	calls := newCallLimiter(2)
+	var inFlight, peak atomic.Int32
+	get := func(ctx context.Context, ref objectRef) (string, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return ref.Name, nil
+	}
+
+	var refs []objectRef
+	for i := 0; i < 10; i++ {
+		refs = append(refs, objectRef{Name: strconv.Itoa(i)})
+	}
+	// Two relations fetching at once share the same limit
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Go(func() {
+			objects := make(map[string]string)
+			if err := getMissing(context.Background(), calls, objects, refs, get); err != nil {
+				t.Errorf("getMissing() unexpected error: %v", err)
+			}
+			if len(objects) != len(refs) {
+				t.Errorf("expected %d objects, got %d", len(refs), len(objects))
+			}
+		})
+	}
+	wg.Wait()
+
+	if peak.Load() > 2 {
+		t.Errorf("expected at most 2 calls in parallel, got %d", peak.Load())
+	}
+}
+
+func TestEnricherStream_Paginates(t *testing.T) {
+	var pods []corev1.Pod
+	for i := 0; i < 5; i++ {
+		pods = append(pods, corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-" + strconv.Itoa(i), Namespace: "default"},
+			Spec:       corev1.PodSpec{NodeName: "node1"},
+		})
+	}
+	client := fake.NewClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}})
+
+	// Serve pods in pages using the continue token as the offset
+	client.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		opts := action.(k8stesting.ListActionImpl).GetListOptions()
+		if opts.Limit != 2 {
+			t.Errorf("expected limit 2, got %d", opts.Limit)
+		}
+		start, _ := strconv.Atoi(opts.Continue)
+		end := min(start+int(opts.Limit), len(pods))
+		list := &corev1.PodList{Items: pods[start:end]}
+		if end < len(pods) {
+			list.Continue = strconv.Itoa(end)
+		}
+		return true, list, nil
+	})
+	var nodeLists atomic.Int32
+	client.PrependReactor("list", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		nodeLists.Add(1)
+		return false, nil, nil
+	})
+
+	var pages [][]string
+	err := NewEnricher(Clients{Kubernetes: client}, Options{
+		Namespace:    "default",
+		Relations:    []string{"node"},
+		ChunkSize:    2,
+		GetThreshold: -1,
+	}).Stream(context.Background(), func(page []PodWithWider) error {
+		var names []string
+		for _, pn := range page {
+			if pn.Node == nil {
+				t.Errorf("expected %s to be joined with node1", pn.Pod.Name)
+			}
+			names = append(names, pn.Pod.Name)
+		}
+		pages = append(pages, names)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream() unexpected error: %v", err)
+	}
+
+	expected := [][]string{{"web-0", "web-1"}, {"web-2", "web-3"}, {"web-4"}}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Errorf("Stream() pages = %v, want %v", pages, expected)
+	}
+	if nodeLists.Load() != 1 {
+		t.Errorf("expected nodes to be listed once per run, got %d", nodeLists.Load())
+	}
+}
+
+func TestEnricherEnrich_GetsOrListsReferencedNodes(t *testing.T) {
+	tests := []struct {
+		name          string
+		labelSelector string
+		threshold     int
+		wantGets      int32
+		wantLists     int32
+	}{
+		{"few nodes are fetched", "app=web", 2, 1, 0},
+		{"many nodes are listed", "", 2, 0, 1},
+		{"negative threshold always lists", "app=web", -1, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objects []runtime.Object
+			for i := 0; i < 3; i++ {
+				nodeName := "node" + strconv.Itoa(i)
+				app := "worker"
+				if i == 0 {
+					app = "web"
+				}
+				objects = append(objects,
+					&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}},
+					&corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{Name: app + "-" + strconv.Itoa(i), Namespace: "default", Labels: map[string]string{"app": app}},
+						Spec:       corev1.PodSpec{NodeName: nodeName},
+					})
+			}
+			client := fake.NewClientset(objects...)
+
+			var gets, lists atomic.Int32
+			client.PrependReactor("get", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				gets.Add(1)
+				return false, nil, nil
+			})
+			client.PrependReactor("list", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				lists.Add(1)
+				return false, nil, nil
+			})
+
+			pods, err := NewEnricher(Clients{Kubernetes: client}, Options{
+				Namespace:     "default",
+				LabelSelector: tt.labelSelector,
+				Relations:     []string{"node"},
+				GetThreshold:  tt.threshold,
+			}).Enrich(context.Background())
+			if err != nil {
+				t.Fatalf("Enrich() unexpected error: %v", err)
+			}
+
+			if gets.Load() != tt.wantGets || lists.Load() != tt.wantLists {
+				t.Errorf("got %d gets and %d lists, want %d gets and %d lists", gets.Load(), lists.Load(), tt.wantGets, tt.wantLists)
+			}
+			for _, pn := range pods {
+				if pn.Node == nil || pn.Node.Name != pn.Pod.Spec.NodeName {
+					t.Errorf("expected %s to be joined with %s, got %v", pn.Pod.Name, pn.Pod.Spec.NodeName, pn.Node)
+				}
+			}
+		})
+	}
+}
+
+func TestEnricherEnrich_MetadataOnly(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default"},
+		Spec:       corev1.PodSpec{NodeName: "node1"},
+	}
+	client := fake.NewClientset(pod)
+	client.PrependReactor("*", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		t.Errorf("unexpected full node request: %v", action)
+		return false, nil, nil
+	})
+
+	scheme := metadatafake.NewTestScheme()
+	if err := metav1.AddMetaToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	metadataClient := metadatafake.NewSimpleMetadataClient(scheme,
+		&metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Node"},
+			ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"kubernetes.io/os": "linux"}},
+		})
+
+	for _, threshold := range []int{0, -1} {
+		pods, err := NewEnricher(Clients{Kubernetes: client, Metadata: metadataClient}, Options{
+			Namespace:    "default",
+			Relations:    []string{"node"},
+			MetadataOnly: []string{"node"},
+			GetThreshold: threshold,
+		}).Enrich(context.Background())
+		if err != nil {
+			t.Fatalf("Enrich() unexpected error: %v", err)
+		}
+
+		if len(pods) != 1 || pods[0].Node == nil {
+			t.Fatalf("expected web-1 to be joined with its node, got %v", pods)
+		}
+		if got := pods[0].Node.Labels["kubernetes.io/os"]; got != "linux" {
+			t.Errorf("expected node labels from metadata, got %q", got)
+		}
+	}
+
+	// Nodes are cluster-scoped, listing them must not be limited to the pod namespace
+	for _, action := range metadataClient.Actions() {
+		if action.GetNamespace() != "" {
+			t.Errorf("unexpected namespaced node request: %v", action)
+		}
+	}
+}
+
+func TestEnricherEnrich_ForbiddenRelation(t *testing.T) {
+	for _, threshold := range []int{0, -1} {
+		t.Run(strconv.Itoa(threshold), func(t *testing.T) {
+			client := fake.NewClientset(
+				&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default"}, Spec: corev1.PodSpec{NodeName: "node1", ServiceAccountName: "web"}},
+				&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-2", Namespace: "default"}, Spec: corev1.PodSpec{NodeName: "node1", ServiceAccountName: "web"}},
+				&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+				&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}},
+			)
+			var requests atomic.Int32
+			client.PrependReactor("*", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				requests.Add(1)
+				return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "nodes"}, "", nil)
+			})
+
+			opts := Options{
+				Namespace:    "default",
+				Relations:    []string{"node", "serviceAccount"},
+				ChunkSize:    1,
+				GetThreshold: threshold,
+			}
+			var warnings []string
+			opts.Warn = func(message string) {
+				warnings = append(warnings, message)
+			}
+
+			pods, err := NewEnricher(Clients{Kubernetes: client}, opts).Enrich(context.Background())
+			if err != nil {
+				t.Fatalf("Enrich() unexpected error: %v", err)
+			}
+			if len(pods) != 2 {
+				t.Fatalf("expected 2 pods, got %d", len(pods))
+			}
+			for _, pn := range pods {
+				if pn.Node != nil || !pn.IsForbidden("node") {
+					t.Errorf("expected %s to have its node forbidden, got %v", pn.Pod.Name, pn.Node)
+				}
+				if pn.ServiceAccount == nil {
+					t.Errorf("expected %s to be joined with its ServiceAccount", pn.Pod.Name)
+				}
+				if result, _ := GetValueByPath(pn, ".node.metadata.name"); result != "<forbidden>" {
+					t.Errorf("GetValueByPath(.node.metadata.name) = %q, want <forbidden>", result)
+				}
+			}
+			if len(warnings) != 1 {
+				t.Errorf("expected a single warning, got %v", warnings)
+			}
+			if requests.Load() != 1 {
+				t.Errorf("expected nodes to be requested once, got %d", requests.Load())
+			}
+
+			opts.Strict = true
+			if _, err := NewEnricher(Clients{Kubernetes: client}, opts).Enrich(context.Background()); !apierrors.IsForbidden(err) {
+				t.Errorf("Enrich() with Strict error = %v, want forbidden", err)
+			}
+		})
+	}
+}
+
+func TestEnricherEnrich_GetErrors(t *testing.T) {
+	client := fake.NewClientset(
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default"}, Spec: corev1.PodSpec{NodeName: "node1", ServiceAccountName: "web"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+	)
+	opts := Options{Namespace: "default", Relations: []string{"node", "serviceAccount"}}
+
+	// A missing object leaves the pod without the relation
+	pods, err := NewEnricher(Clients{Kubernetes: client}, opts).Enrich(context.Background())
+	if err != nil {
+		t.Fatalf("Enrich() unexpected error: %v", err)
+	}
+	if pods[0].Node == nil || pods[0].ServiceAccount != nil {
+		t.Errorf("expected node1 and no ServiceAccount, got %v and %v", pods[0].Node, pods[0].ServiceAccount)
+	}
+
+	// Any other failure fails the run instead of showing an empty value
+	client.PrependReactor("get", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewInternalError(errors.New("etcd unavailable"))
+	})
+	if _, err := NewEnricher(Clients{Kubernetes: client}, opts).Enrich(context.Background()); !apierrors.IsInternalError(err) {
+		t.Errorf("Enrich() error = %v, want internal error", err)
+	}
+}
+
+func TestEnricherEnrich_CachesNodes(t *testing.T) {
+	nodes := func(rv2 string) []runtime.Object {
+		return []runtime.Object{
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", ResourceVersion: "1"}},
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2", ResourceVersion: rv2, Labels: map[string]string{"rv": rv2}}},
+		}
+	}
+	metadataFor := func(rv2 string) *metadatafake.FakeMetadataClient {
+		scheme := metadatafake.NewTestScheme()
+		if err := metav1.AddMetaToScheme(scheme); err != nil {
+			t.Fatal(err)
+		}
+		var objects []runtime.Object
+		for _, obj := range nodes(rv2) {
+			node := obj.(*corev1.Node)
+			objects = append(objects, &metav1.PartialObjectMetadata{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Node"},
+				ObjectMeta: node.ObjectMeta,
+			})
+		}
+		return metadatafake.NewSimpleMetadataClient(scheme, objects...)
+	}
+	pods := []runtime.Object{
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default"}, Spec: corev1.PodSpec{NodeName: "node1"}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-2", Namespace: "default"}, Spec: corev1.PodSpec{NodeName: "node2"}},
+	}
+	cache := NewCache(t.TempDir(), "arn:aws:eks:cluster/prod", time.Hour)
+
+	tests := []struct {
+		name      string
+		rv2       string
+		wantLists int32
+		wantGets  int32
+	}{
+		{"first run lists", "1", 1, 0},
+		{"unchanged nodes are cached", "1", 0, 0},
+		{"changed nodes are fetched", "2", 0, 1},
+		{"refreshed cache is reused", "2", 0, 0},
+	}
+
+	for _, tt := range tests {
+		client := fake.NewClientset(append(nodes(tt.rv2), pods...)...)
+		var lists, gets atomic.Int32
+		client.PrependReactor("list", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			lists.Add(1)
+			return false, nil, nil
+		})
+		client.PrependReactor("get", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			gets.Add(1)
+			return false, nil, nil
+		})
+
+		result, err := NewEnricher(Clients{Kubernetes: client, Metadata: metadataFor(tt.rv2)}, Options{
+			Namespace:    "default",
+			Relations:    []string{"node"},
+			GetThreshold: 1,
+			Cache:        cache,
+		}).Enrich(context.Background())
+		if err != nil {
+			t.Fatalf("%s: Enrich() unexpected error: %v", tt.name, err)
+		}
+
+		if lists.Load() != tt.wantLists || gets.Load() != tt.wantGets {
+			t.Errorf("%s: got %d lists and %d gets, want %d lists and %d gets", tt.name, lists.Load(), gets.Load(), tt.wantLists, tt.wantGets)
+		}
+		for _, pn := range result {
+			if pn.Node == nil || pn.Node.Name != pn.Pod.Spec.NodeName {
+				t.Fatalf("%s: expected %s to be joined with %s, got %v", tt.name, pn.Pod.Name, pn.Pod.Spec.NodeName, pn.Node)
+			}
+			if pn.Node.Name == "node2" && pn.Node.Labels["rv"] != tt.rv2 {
+				t.Errorf("%s: expected node2 at resourceVersion %s, got labels %v", tt.name, tt.rv2, pn.Node.Labels)
+			}
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(cache.dir, "nodes.json")); err != nil {
+		t.Errorf("expected nodes to be cached: %v", err)
+	}
+}
+
+func TestEnricherEnrich_PersistentVolumesDoNotDeadlock(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default"}}
+	var claims []corev1.PersistentVolumeClaim
+	for i := 0; i < 5; i++ {
+		name := "data-" + strconv.Itoa(i)
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name:         name,
+			VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: name}},
+		})
+		// Unbound claims are not in the PV list and are looked up one by one
+		claims = append(claims, corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}})
+	}
+	client := fake.NewClientset(pod)
+	// Serve claims in pages using the continue token as the offset
+	client.PrependReactor("list", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		// Slow pages keep the claim lookup busy while volumes are fetched
+		time.Sleep(10 * time.Millisecond)
+		opts := action.(k8stesting.ListActionImpl).GetListOptions()
+		start, _ := strconv.Atoi(opts.Continue)
+		end := min(start+int(opts.Limit), len(claims))
+		list := &corev1.PersistentVolumeClaimList{Items: claims[start:end]}
+		if end < len(claims) {
+			list.Continue = strconv.Itoa(end)
+		}
+		return true, list, nil
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := NewEnricher(Clients{Kubernetes: client}, Options{
+			Namespace:    "default",
+			Relations:    []string{"pvcs", "pvs"},
+			ChunkSize:    1,
+			GetThreshold: -1,
+		}).Enrich(context.Background())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Enrich() unexpected error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Enrich() did not return, the PVC and PV relations deadlocked")
+	}
+}
+
+func TestEnricherEnrich_CachesPersistentVolumes(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default"}}
+	objects := []runtime.Object{pod}
+	var metadataObjects []runtime.Object
+	for _, claim := range []string{"data", "logs"} {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name:         claim,
+			VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim}},
+		})
+		pv := &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-" + claim, ResourceVersion: "1"},
+			Spec:       corev1.PersistentVolumeSpec{ClaimRef: &corev1.ObjectReference{Namespace: "default", Name: claim}},
+		}
+		objects = append(objects, pv)
+		metadataObjects = append(metadataObjects, &metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolume"},
+			ObjectMeta: pv.ObjectMeta,
+		})
+	}
+	scheme := metadatafake.NewTestScheme()
+	if err := metav1.AddMetaToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewCache(t.TempDir(), "prod", time.Hour)
+
+	// The first run lists the volumes, the second reuses the cached list
+	for _, wantLists := range []int32{1, 0} {
+		client := fake.NewClientset(objects...)
+		var lists atomic.Int32
+		client.PrependReactor("list", "persistentvolumes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			lists.Add(1)
+			return false, nil, nil
+		})
+
+		pods, err := NewEnricher(Clients{Kubernetes: client, Metadata: metadatafake.NewSimpleMetadataClient(scheme, metadataObjects...)}, Options{
+			Namespace:    "default",
+			Relations:    []string{"pvs"},
+			GetThreshold: 1,
+			Cache:        cache,
+		}).Enrich(context.Background())
+		if err != nil {
+			t.Fatalf("Enrich() unexpected error: %v", err)
+		}
+		if pvs := pods[0].PVs; len(pvs) != 2 || pvs[0].Name != "pv-data" || pvs[1].Name != "pv-logs" {
+			t.Errorf("expected pv-data and pv-logs, got %v", pvs)
+		}
+		if lists.Load() != wantLists {
+			t.Errorf("expected %d PV lists, got %d", wantLists, lists.Load())
+		}
+	}
+}
+
+func TestExpandContainers(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "init", Image: "busybox"}},
+			Containers: []corev1.Container{{
+				Name:  "web",
+				Image: "nginx:1.27",
+				Resources: corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+				},
+			}},
+			EphemeralContainers: []corev1.EphemeralContainer{{
+				EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debug", Image: "busybox"},
+			}},
+		},
+		Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "init",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Completed"}},
+			}},
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:         "web",
+				RestartCount: 3,
+				State:        corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+				LastTerminationState: corev1.ContainerState{
+					Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137},
+				},
+			}},
+		},
+	}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+
+	rows := ExpandContainers([]PodWithWider{{Pod: pod, Node: node}})
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows, got %d", len(rows))
+	}
+
+	tests := []struct {
+		row      int
+		path     string
+		expected string
+	}{
+		{0, ".container.name", "init"},
+		{0, ".container.type", "init"},
+		{0, ".container.status.state.terminated.reason", "Completed"},
+		{1, ".container.image", "nginx:1.27"},
+		{1, ".container.resources.limits.cpu", "500m"},
+		{1, ".container.status.restartCount", "3"},
+		{1, ".container.status.lastState.terminated.reason", "OOMKilled"},
+		{1, ".node.metadata.name", "node1"},
+		{2, ".container.type", "ephemeral"},
+		{2, ".container.status", "<none>"},
+	}
+	for _, tt := range tests {
+		result, err := GetValueByPath(rows[tt.row], tt.path)
+		if err != nil {
+			t.Errorf("GetValueByPath(%q) unexpected error: %v", tt.path, err)
+		} else if result != tt.expected {
+			t.Errorf("GetValueByPath(%q) on row %d = %v, want %v", tt.path, tt.row, result, tt.expected)
+		}
+	}
+
+	if state := ContainerState(*rows[1].Container); state != "CrashLoopBackOff" {
+		t.Errorf("ContainerState() = %q, want CrashLoopBackOff", state)
+	}
+	if last := LastTermination(*rows[1].Container); last != "OOMKilled (137)" {
+		t.Errorf("LastTermination() = %q, want OOMKilled (137)", last)
+	}
+	if result, _ := GetValueByPath(PodWithWider{Pod: pod}, ".container.name"); result != "<none>" {
+		t.Errorf("expected <none> for .container on pod rows, got %q", result)
+	}
+}
+
+func TestImageInventory(t *testing.T) {
+	node := func(name string, images ...corev1.ContainerImage) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}, Status: corev1.NodeStatus{Images: images}}
+	}
+	pod := func(namespace, name, nodeName string, images ...string) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       corev1.PodSpec{NodeName: nodeName},
+		}
+		for i, image := range images {
+			containerName := "c" + strconv.Itoa(i)
+			repository, _, _ := strings.Cut(image, ":")
+			pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: containerName, Image: image})
+			pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{
+				Name:    containerName,
+				ImageID: "docker.io/library/" + repository + "@sha256:" + repository,
+			})
+		}
+		return pod
+	}
+
+	node1 := node("node1", corev1.ContainerImage{Names: []string{"docker.io/library/nginx@sha256:nginx", "docker.io/library/nginx:1.27"}, SizeBytes: 72_000_000})
+	node2 := node("node2")
+	podNodes := []PodWithWider{
+		{Pod: pod("default", "web-1", "node1", "nginx:1.27", "busybox"), Node: node1},
+		{Pod: pod("shop", "web-2", "node2", "nginx:1.27"), Node: node2},
+		{Pod: pod("shop", "web-3", "", "nginx:1.27")},
+	}
+
+	usages := ImageInventory(podNodes)
+	if len(usages) != 2 {
+		t.Fatalf("expected 2 images, got %+v", usages)
+	}
+	nginx := usages[1]
+	expected := ImageUsage{
+		Image:      "nginx:1.27",
+		Digests:    []string{"sha256:nginx"},
+		Namespaces: []string{"default", "shop"},
+		Pods:       3,
+		Containers: 3,
+		Nodes:      []string{"node1", "node2"},
+		PresentOn:  []string{"node1"},
+		SizeBytes:  72_000_000,
+	}
+	if !reflect.DeepEqual(nginx, expected) {
+		t.Errorf("ImageInventory() nginx = %+v, want %+v", nginx, expected)
+	}
+	if usages[0].Image != "busybox" || usages[0].Pods != 1 || len(usages[0].PresentOn) != 0 {
+		t.Errorf("unexpected busybox usage %+v", usages[0])
+	}
+
+	var out strings.Builder
+	if err := PrintReport(&out, ReportImages, PrintOptions{}, podNodes); err != nil {
+		t.Fatalf("PrintReport() unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), "1/2") || !strings.Contains(out.String(), "72.0MB") {
+		t.Errorf("PrintReport() output missing presence or size:\n%s", out.String())
+	}
+}
+
+func TestNormalizeImage(t *testing.T) {
+	tests := []struct {
+		image    string
+		expected string
+	}{
+		{"nginx", "docker.io/library/nginx:latest"},
+		{"nginx:1.27", "docker.io/library/nginx:1.27"},
+		{"bitnami/redis:7", "docker.io/bitnami/redis:7"},
+		{"registry.k8s.io/pause:3.10", "registry.k8s.io/pause:3.10"},
+		{"localhost:5000/app", "localhost:5000/app:latest"},
+		{"nginx@sha256:abc", "docker.io/library/nginx@sha256:abc"},
+	}
+
+	for _, tt := range tests {
+		if result := normalizeImage(tt.image); result != tt.expected {
+			t.Errorf("normalizeImage(%q) = %q, want %q", tt.image, result, tt.expected)
+		}
+	}
+}
+
+func TestComputePodResources(t *testing.T) {
+	requests := func(cpu, memory string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpu),
+			corev1.ResourceMemory: resource.MustParse(memory),
+		}}
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			NodeName: "node1",
+			// The init container needs more CPU than the regular containers together
+			InitContainers: []corev1.Container{{Name: "migrate", Resources: requests("1", "64Mi")}},
+			Containers: []corev1.Container{
+				{Name: "web", Resources: requests("250m", "256Mi")},
+				{Name: "proxy", Resources: requests("250m", "128Mi")},
+			},
+			Overhead: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+		},
+	}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("4"),
+			corev1.ResourceMemory: resource.MustParse("4Gi"),
+		}},
+	}
+	pn := PodWithWider{Pod: pod, Node: node}
+
+	r := ComputePodResources(pn)
+	if cpu := r.Requests[corev1.ResourceCPU]; cpu.String() != "1100m" {
+		t.Errorf("expected CPU requests of 1100m, got %s", cpu.String())
+	}
+	if memory := r.Requests[corev1.ResourceMemory]; memory.String() != "384Mi" {
+		t.Errorf("expected memory requests of 384Mi, got %s", memory.String())
+	}
+	if r.RequestsPercent[corev1.ResourceCPU] != 27 || r.RequestsPercent[corev1.ResourceMemory] != 9 {
+		t.Errorf("unexpected request percentages %v", r.RequestsPercent)
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{".resources.requests.cpu", "1100m"},
+		{".resources.allocatable.memory", "4Gi"},
+		{".resources.requestsPercent.cpu", "27"},
+		{".resources.limits.cpu", "<none>"},
+	}
+	for _, tt := range tests {
+		result, err := GetValueByPath(pn, tt.path)
+		if err != nil {
+			t.Errorf("GetValueByPath(%q) unexpected error: %v", tt.path, err)
+		} else if result != tt.expected {
+			t.Errorf("GetValueByPath(%q) = %v, want %v", tt.path, result, tt.expected)
+		}
+	}
+
+	if r := ComputePodResources(PodWithWider{Pod: pod}); r.Allocatable != nil || r.RequestsPercent != nil {
+		t.Errorf("expected no node resources without a node, got %+v", r)
+	}
+}
+
+func TestExplainScheduling(t *testing.T) {
+	allocatable := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("2"),
+		corev1.ResourceMemory: resource.MustParse("4Gi"),
+		corev1.ResourcePods:   resource.MustParse("110"),
+	}
+	nodes := []*corev1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "gpu-1", Labels: map[string]string{"pool": "gpu"}},
+			Spec:       corev1.NodeSpec{Taints: []corev1.Taint{{Key: "gpu", Value: "true", Effect: corev1.TaintEffectNoSchedule}}},
+			Status:     corev1.NodeStatus{Allocatable: allocatable},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-1", Labels: map[string]string{"pool": "web"}},
+			Status:     corev1.NodeStatus{Allocatable: allocatable},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-2", Labels: map[string]string{"pool": "web"}},
+			Status:     corev1.NodeStatus{Allocatable: allocatable},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-3", Labels: map[string]string{"pool": "web"}},
+			Spec:       corev1.NodeSpec{Unschedulable: true},
+			Status:     corev1.NodeStatus{Allocatable: allocatable},
+		},
+	}
+	usage := map[string]*NodeUsage{}
+	addNodeUsage(usage, &corev1.Pod{
+		Spec: corev1.PodSpec{
+			NodeName: "web-1",
+			Containers: []corev1.Container{{Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1500m")},
+			}}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	})
+	// Completed pods do not hold resources
+	addNodeUsage(usage, &corev1.Pod{
+		Spec: corev1.PodSpec{
+			NodeName: "web-2",
+			Containers: []corev1.Container{{Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+			}}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodSucceeded},
+	})
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+						MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "pool", Operator: corev1.NodeSelectorOpIn, Values: []string{"web"}}},
+					}},
+				},
+			}},
+			Containers: []corev1.Container{{Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+			}}},
+		},
+	}
+
+	fits := ExplainScheduling(pod, nodes, usage)
+	expected := []NodeFit{
+		{Node: "web-2"},
+		{Node: "gpu-1", Reasons: []string{"required node affinity not matched", "untolerated taint gpu=true:NoSchedule"}},
+		{Node: "web-1", Reasons: []string{"insufficient cpu (requested 1, free 500m of 2)"}},
+		{Node: "web-3", Reasons: []string{"node is unschedulable"}},
+	}
+	if !reflect.DeepEqual(fits, expected) {
+		t.Errorf("ExplainScheduling() = %+v, want %+v", fits, expected)
+	}
+
+	pod.Spec.NodeSelector = map[string]string{"pool": "gpu"}
+	pod.Spec.Tolerations = []corev1.Toleration{{Key: "gpu", Operator: corev1.TolerationOpExists}}
+	pod.Spec.Affinity = nil
+	fits = ExplainScheduling(pod, nodes, usage)
+	if !fits[0].Fits() || fits[0].Node != "gpu-1" {
+		t.Errorf("expected the tolerated gpu node to fit first, got %+v", fits)
+	}
+	if reasons := fits[1].Reasons; len(reasons) == 0 || reasons[0] != "nodeSelector pool=gpu not matched" {
+		t.Errorf("expected web-1 to fail the node selector, got %v", reasons)
+	}
+}
+
+func TestEnricherExplain_Forbidden(t *testing.T) {
+	newClient := func() *fake.Clientset {
+		return fake.NewClientset(
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "big", Namespace: "default"},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{
+					Name:      "app",
+					Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("8")}},
+				}}},
+				Status: corev1.PodStatus{Phase: corev1.PodPending},
+			},
+			&corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+				Status:     corev1.NodeStatus{Allocatable: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")}},
+			},
+		)
+	}
+	forbidden := func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() != "" {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewForbidden(action.GetResource().GroupResource(), "", nil)
+	}
+
+	t.Run("pods", func(t *testing.T) {
+		client := newClient()
+		client.PrependReactor("list", "pods", forbidden)
+		var warnings []string
+		opts := Options{Namespace: "default", Warn: func(message string) { warnings = append(warnings, message) }}
+
+		explanations, err := NewEnricher(Clients{Kubernetes: client}, opts).Explain(context.Background())
+		if err != nil {
+			t.Fatalf("Explain() unexpected error: %v", err)
+		}
+		// Without the pods of other namespaces the free resources are unknown
+		expected := []NodeFit{{Node: "node1"}}
+		if len(explanations) != 1 || !reflect.DeepEqual(explanations[0].Nodes, expected) {
+			t.Errorf("Explain() = %+v, want nodes %+v", explanations, expected)
+		}
+		if len(warnings) != 1 {
+			t.Errorf("expected a single warning, got %v", warnings)
+		}
+
+		opts.Strict = true
+		if _, err := NewEnricher(Clients{Kubernetes: client}, opts).Explain(context.Background()); !apierrors.IsForbidden(err) {
+			t.Errorf("Explain() with Strict error = %v, want forbidden", err)
+		}
+	})
+
+	t.Run("nodes", func(t *testing.T) {
+		client := newClient()
+		client.PrependReactor("list", "nodes", forbidden)
+		var warnings []string
+		opts := Options{Namespace: "default", Warn: func(message string) { warnings = append(warnings, message) }}
+
+		explanations, err := NewEnricher(Clients{Kubernetes: client}, opts).Explain(context.Background())
+		if err != nil {
+			t.Fatalf("Explain() unexpected error: %v", err)
+		}
+		if len(explanations) != 0 || len(warnings) != 1 {
+			t.Errorf("expected no explanations and a single warning, got %+v and %v", explanations, warnings)
+		}
+
+		opts.Strict = true
+		if _, err := NewEnricher(Clients{Kubernetes: client}, opts).Explain(context.Background()); !apierrors.IsForbidden(err) {
+			t.Errorf("Explain() with Strict error = %v, want forbidden", err)
+		}
+	})
+}
+
+func TestTopologySpread(t *testing.T) {
+	node := func(name, zone string) *corev1.Node {
+		labels := map[string]string{corev1.LabelTopologyRegion: "eu-west-1"}
+		if zone != "" {
+			labels[corev1.LabelTopologyZone] = zone
+		}
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	controller := true
+	pod := func(name, ownerKind, ownerName, hash, nodeName string) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       corev1.PodSpec{NodeName: nodeName},
+		}
+		if ownerKind != "" {
+			pod.OwnerReferences = []metav1.OwnerReference{{Kind: ownerKind, Name: ownerName, Controller: &controller}}
+		}
+		if hash != "" {
+			pod.Labels = map[string]string{"pod-template-hash": hash}
+		}
+		return pod
+	}
+	a, b, c := node("node-a", "eu-west-1a"), node("node-b", "eu-west-1b"), node("node-c", "")
+	// Argo Rollouts label their pods with rollouts-pod-template-hash instead
+	canary := pod("api-7c9b-1", "ReplicaSet", "api-7c9b", "", "node-b")
+	canary.Labels = map[string]string{"rollouts-pod-template-hash": "7c9b"}
+
+	spreads := TopologySpread([]PodWithWider{
+		{Pod: pod("web-5d4f-1", "ReplicaSet", "web-5d4f", "5d4f", "node-a"), Node: a},
+		{Pod: pod("web-5d4f-2", "ReplicaSet", "web-5d4f", "5d4f", "node-b"), Node: b},
+		{Pod: pod("db-0", "StatefulSet", "db", "", "node-a"), Node: a},
+		{Pod: pod("db-1", "StatefulSet", "db", "", "node-a"), Node: a},
+		{Pod: pod("db-2", "StatefulSet", "db", "", "")},
+		{Pod: pod("cache-1", "ReplicaSet", "cache", "", "node-c"), Node: c},
+		{Pod: pod("cache-2", "ReplicaSet", "cache", "", "node-b"), Node: b},
+		{Pod: pod("debug", "", "", "", "node-a"), Node: a},
+		{Pod: canary, Node: b},
+	})
+
+	expected := []WorkloadSpread{
+		{
+			Namespace: "default", Kind: "Deployment", Name: "web", Pods: 2,
+			Zones:   map[string]int{"eu-west-1a": 1, "eu-west-1b": 1},
+			Regions: map[string]int{"eu-west-1": 2},
+			Nodes:   map[string]int{"node-a": 1, "node-b": 1},
+		},
+		{
+			Namespace: "default", Kind: "Pod", Name: "debug", Pods: 1,
+			Zones:   map[string]int{"eu-west-1a": 1},
+			Regions: map[string]int{"eu-west-1": 1},
+			Nodes:   map[string]int{"node-a": 1},
+		},
+		{
+			// A ReplicaSet without pod-template-hash is not owned by a Deployment
+			Namespace: "default", Kind: "ReplicaSet", Name: "cache", Pods: 2,
+			Zones:   map[string]int{"<none>": 1, "eu-west-1b": 1},
+			Regions: map[string]int{"eu-west-1": 2},
+			Nodes:   map[string]int{"node-b": 1, "node-c": 1},
+		},
+		{
+			Namespace: "default", Kind: "Rollout", Name: "api", Pods: 1,
+			Zones:   map[string]int{"eu-west-1b": 1},
+			Regions: map[string]int{"eu-west-1": 1},
+			Nodes:   map[string]int{"node-b": 1},
+		},
+		{
+			Namespace: "default", Kind: "StatefulSet", Name: "db", Pods: 3, Unscheduled: 1,
+			Zones:   map[string]int{"eu-west-1a": 2},
+			Regions: map[string]int{"eu-west-1": 2},
+			Nodes:   map[string]int{"node-a": 2},
+			Flags:   []string{SpreadSingleZone, SpreadSingleNode},
+		},
+	}
+	if !reflect.DeepEqual(spreads, expected) {
+		t.Errorf("TopologySpread() =\n%+v\nwant\n%+v", spreads, expected)
+	}
+}
+
+func TestNodeWideColumns(t *testing.T) {
+	tests := []struct {
+		name     string
+		pn       PodWithWider
+		expected []string
+	}{
+		{
+			name: "cordoned node under pressure",
+			pn: PodWithWider{Node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+					corev1.LabelTopologyZone:       "eu-west-1a",
+					corev1.LabelInstanceTypeStable: "m5.large",
+				}},
+				Spec: corev1.NodeSpec{
+					Unschedulable: true,
+					Taints: []corev1.Taint{
+						{Key: corev1.TaintNodeUnschedulable, Effect: corev1.TaintEffectNoSchedule},
+						{Key: "gpu", Value: "true", Effect: corev1.TaintEffectNoSchedule},
+					},
+				},
+				Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+					{Type: corev1.NodeReady, Status: corev1.ConditionFalse},
+					{Type: corev1.NodePIDPressure, Status: corev1.ConditionTrue},
+					{Type: corev1.NodeDiskPressure, Status: corev1.ConditionTrue},
+					{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionFalse},
+				}},
+			}},
+			expected: []string{"eu-west-1a", "m5.large", "NotReady,SchedulingDisabled", "Disk,PID", "gpu=true:NoSchedule"},
+		},
+		{
+			name: "node that stopped reporting",
+			pn: PodWithWider{Node: &corev1.Node{
+				Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+					{Type: corev1.NodeReady, Status: corev1.ConditionUnknown},
+				}},
+			}},
+			expected: []string{"<none>", "<none>", "NotReady", "<none>", "<none>"},
+		},
+		{
+			name:     "node without status",
+			pn:       PodWithWider{Node: &corev1.Node{}},
+			expected: []string{"<none>", "<none>", "Unknown", "<none>", "<none>"},
+		},
+		{
+			name:     "forbidden node",
+			pn:       PodWithWider{Forbidden: []string{"node"}},
+			expected: []string{"<forbidden>", "<forbidden>", "<forbidden>", "<forbidden>", "<forbidden>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nodeWideColumns(tt.pn); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("nodeWideColumns() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPodWideColumns(t *testing.T) {
+	controller := true
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "web-5d4f-abcde",
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "Node", Name: "node1"},
+				{Kind: "ReplicaSet", Name: "web-5d4f", Controller: &controller},
+			},
+		},
+		Spec: corev1.PodSpec{
+			ServiceAccountName: "web",
+			ReadinessGates: []corev1.PodReadinessGate{
+				{ConditionType: "target-health.elbv2.k8s.aws/web"},
+				{ConditionType: "example.com/warm"},
+			},
+			Volumes: []corev1.Volume{
+				{Name: "data", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data-0"}}},
+				{Name: "config", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{}}},
+				{Name: "logs", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "logs-0"}}},
+			},
+		},
+		Status: corev1.PodStatus{
+			NominatedNodeName: "node2",
+			Conditions: []corev1.PodCondition{
+				{Type: "target-health.elbv2.k8s.aws/web", Status: corev1.ConditionTrue},
+				{Type: "example.com/warm", Status: corev1.ConditionFalse},
+			},
+		},
+	}
+
+	expected := []string{"node2", "1/2", "ReplicaSet/web-5d4f", "web", "data-0,logs-0"}
+	if got := podWideColumns(pod); !reflect.DeepEqual(got, expected) {
+		t.Errorf("podWideColumns() = %v, want %v", got, expected)
+	}
+}
+
+func TestPrint_StructuredList(t *testing.T) {
+	pn := PodWithWider{
+		Pod:  &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1"}},
+		Node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+	}
+
+	tests := []struct {
+		format   string
+		podNodes []PodWithWider
+		contains []string
+	}{
+		{"json", []PodWithWider{pn}, []string{`"apiVersion": "wider.io/v1alpha1"`, `"kind": "PodWithWiderList"`, `"pod": {`, `"node": {`, `"serviceAccount": null`}},
+		{"json", nil, []string{`"items": []`}},
+		{"yaml", []PodWithWider{pn}, []string{"apiVersion: wider.io/v1alpha1", "kind: PodWithWiderList", "- node:", "pvcs: null"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Print(&out, PrintOptions{OutputFormat: tt.format}, tt.podNodes); err != nil {
+				t.Fatalf("Print() unexpected error: %v", err)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("Print() output does not contain %q:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+}
+
+func TestPodWithWider_JSONRelatedFields(t *testing.T) {
+	vpa := map[string]interface{}{"metadata": map[string]interface{}{"name": "web-vpa"}}
+	pn := PodWithWider{Pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1"}}}
+	pn.SetRelated("vpa", vpa)
+
+	data, err := json.Marshal(pn)
+	if err != nil {
+		t.Fatalf("Marshal() unexpected error: %v", err)
+	}
+	// Custom relations are fields named like their root, as .vpa in custom-columns
+	if !strings.HasSuffix(string(data), `"vpa":{"metadata":{"name":"web-vpa"}}}`) || strings.Contains(string(data), "related") {
+		t.Errorf("Marshal() = %s, want a top-level vpa field", data)
+	}
+
+	var decoded PodWithWider
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal() unexpected error: %v", err)
+	}
+	if decoded.Pod.Name != "web-1" || !reflect.DeepEqual(decoded.Related, map[string]interface{}{"vpa": vpa}) {
+		t.Errorf("Unmarshal() = %+v, want web-1 with vpa", decoded)
+	}
+}
+
+func TestPrint_Delimited(t *testing.T) {
+	pn := PodWithWider{Pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:        "web-1",
+		Annotations: map[string]string{"note": `drained, "maintenance"`},
+	}}}
+
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{
+			format:   "csv=NAME:.pod.metadata.name,NOTE:.pod.metadata.annotations.note,NODE:.node.metadata.name",
+			expected: "NAME,NOTE,NODE\r\nweb-1,\"drained, \"\"maintenance\"\"\",<none>\r\n",
+		},
+		{
+			format:   "tsv=NAME:.pod.metadata.name,NOTE:.pod.metadata.annotations.note",
+			expected: "NAME\tNOTE\nweb-1\t\"drained, \"\"maintenance\"\"\"\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Print(&out, PrintOptions{OutputFormat: tt.format}, []PodWithWider{pn}); err != nil {
+				t.Fatalf("Print() unexpected error: %v", err)
+			}
+			if out.String() != tt.expected {
+				t.Errorf("Print() = %q, want %q", out.String(), tt.expected)
+			}
+		})
+	}
+
+	if err := Print(&bytes.Buffer{}, PrintOptions{OutputFormat: "csv=NAME"}, nil); err == nil || !strings.Contains(err.Error(), "invalid csv format") {
+		t.Errorf("expected an invalid csv format error, got %v", err)
+	}
+}
+
+func TestPrint_Document(t *testing.T) {
+	podNodes := []PodWithWider{
+		{Pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        "web-1",
+			Namespace:   "shop",
+			Annotations: map[string]string{"note": "a|b\n<c>"},
+		}}},
+		{Pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db-0", Namespace: "data"}}},
+	}
+
+	tests := []struct {
+		format   string
+		groupBy  string
+		expected string
+	}{
+		{
+			format:   "markdown=NAME:.pod.metadata.name,NOTE:.pod.metadata.annotations.note",
+			expected: "| NAME | NOTE |\n| --- | --- |\n| web-1 | a\\|b<br><c> |\n| db-0 | <none> |\n",
+		},
+		{
+			format:   "markdown=NAME:.pod.metadata.name",
+			groupBy:  ".pod.metadata.namespace",
+			expected: "## data\n\n| NAME |\n| --- |\n| db-0 |\n\n## shop\n\n| NAME |\n| --- |\n| web-1 |\n",
+		},
+		{
+			format:   "html=NOTE:.pod.metadata.annotations.note",
+			expected: "<table>\n<thead>\n<tr><th>NOTE</th></tr>\n</thead>\n<tbody>\n<tr><td>a|b\n&lt;c&gt;</td></tr>\n<tr><td>&lt;none&gt;</td></tr>\n</tbody>\n</table>\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Print(&out, PrintOptions{OutputFormat: tt.format, GroupBy: tt.groupBy}, podNodes); err != nil {
+				t.Fatalf("Print() unexpected error: %v", err)
+			}
+			if out.String() != tt.expected {
+				t.Errorf("Print() = %q, want %q", out.String(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestEnricherEnrich_PersistentVolumes(t *testing.T) {
+	claimPod := func(name string, claims ...string) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+		for _, claim := range claims {
+			pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+				Name:         claim,
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim}},
+			})
+		}
+		return pod
+	}
+	objects := []runtime.Object{
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "default"}, Spec: corev1.PersistentVolumeClaimSpec{VolumeName: "pv-data"}},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pending", Namespace: "default"}},
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-data"},
+			Spec:       corev1.PersistentVolumeSpec{ClaimRef: &corev1.ObjectReference{Namespace: "default", Name: "data"}},
+		},
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-other"},
+			Spec:       corev1.PersistentVolumeSpec{ClaimRef: &corev1.ObjectReference{Namespace: "other", Name: "data"}},
+		},
+		claimPod("web-1", "data", "pending"),
+	}
+
+	// A threshold of -1 always lists, the default fetches through the claim
+	for _, threshold := range []int{0, -1} {
+		t.Run(strconv.Itoa(threshold), func(t *testing.T) {
+			client := fake.NewClientset(objects...)
+			pods, err := NewEnricher(Clients{Kubernetes: client}, Options{
+				Namespace:    "default",
+				Relations:    []string{"pvcs", "pvs"},
+				GetThreshold: threshold,
+			}).Enrich(context.Background())
+			if err != nil {
+				t.Fatalf("Enrich() unexpected error: %v", err)
+			}
+			if pvs := pods[0].PVs; len(pvs) != 1 || pvs[0].Name != "pv-data" {
+				t.Errorf("expected pv-data, got %v", pvs)
+			}
+			if pvcs := pods[0].PVCs; len(pvcs) != 2 {
+				t.Errorf("expected 2 PVCs, got %v", pvcs)
+			}
+
+			listed := false
+			claimGets := 0
+			for _, action := range client.Actions() {
+				if action.GetVerb() == "list" && action.GetResource().Resource == "persistentvolumes" {
+					listed = true
+				}
+				if action.GetVerb() == "get" && action.GetResource().Resource == "persistentvolumeclaims" {
+					claimGets++
+				}
+			}
+			if listed != (threshold < 0) {
+				t.Errorf("expected PVs listed = %v, got actions %v", threshold < 0, client.Actions())
+			}
+			// Claims are shared with the PVC relation rather than fetched again for their volume
+			if threshold >= 0 && claimGets != 2 {
+				t.Errorf("expected each claim to be fetched once, got %d gets", claimGets)
+			}
+		})
+	}
+}
+
+func TestPrint_Graph(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{corev1.LabelTopologyZone: "eu-west-1a"}}}
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "shop"}}
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-1", Labels: map[string]string{corev1.LabelTopologyZone: "eu-west-1a"}},
+		Spec:       corev1.PersistentVolumeSpec{ClaimRef: &corev1.ObjectReference{Namespace: "shop", Name: "data"}},
+	}
+	podNodes := []PodWithWider{
+		{
+			Pod:            &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db-0", Namespace: "shop"}},
+			Node:           node,
+			ServiceAccount: &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "shop"}},
+			PVCs:           []*corev1.PersistentVolumeClaim{pvc},
+			PVs:            []*corev1.PersistentVolume{pv},
+		},
+		{
+			Pod:  &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "shop"}},
+			Node: node,
+		},
+	}
+
+	tests := []struct {
+		format     string
+		subgraphBy string
+		expected   string
+	}{
+		{
+			format:     "dot",
+			subgraphBy: SubgraphNamespace,
+			expected: `digraph wider {
+  rankdir=LR;
+  node [shape=box];
+  "node/node-a" [label="node/node-a"];
+  "pv/pv-1" [label="pv/pv-1"];
+  subgraph cluster_1 {
+    label="namespace shop";
+    "pod/shop/db-0" [label="pod/db-0"];
+    "serviceaccount/shop/db" [label="serviceaccount/db"];
+    "pvc/shop/data" [label="pvc/data"];
+    "pod/shop/web-1" [label="pod/web-1"];
+  }
+  "pod/shop/db-0" -> "node/node-a" [label="runs on"];
+  "pod/shop/db-0" -> "serviceaccount/shop/db" [label="uses"];
+  "pod/shop/db-0" -> "pvc/shop/data" [label="mounts"];
+  "pvc/shop/data" -> "pv/pv-1" [label="bound to"];
+  "pod/shop/web-1" -> "node/node-a" [label="runs on"];
+}
+`,
+		},
+		{
+			format:     "mermaid",
+			subgraphBy: SubgraphZone,
+			expected: `graph LR
+  v2["serviceaccount/shop/db"]
+  v3["pvc/shop/data"]
+  subgraph g1["zone eu-west-1a"]
+    v0["pod/shop/db-0"]
+    v1["node/node-a"]
+    v4["pv/pv-1"]
+    v5["pod/shop/web-1"]
+  end
+  v0 -->|"runs on"| v1
+  v0 -->|"uses"| v2
+  v0 -->|"mounts"| v3
+  v3 -->|"bound to"| v4
+  v5 -->|"runs on"| v1
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Print(&out, PrintOptions{OutputFormat: tt.format, SubgraphBy: tt.subgraphBy}, podNodes); err != nil {
+				t.Fatalf("Print() unexpected error: %v", err)
+			}
+			if out.String() != tt.expected {
+				t.Errorf("Print() =\n%s\nwant\n%s", out.String(), tt.expected)
+			}
+		})
+	}
+}